@@ -0,0 +1,98 @@
+package sm2rsign
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"io"
+
+	"github.com/emmansun/gmsm/sm2"
+)
+
+// possessionDomain prefixes the ring ID in the message signed by a proof of
+// possession, so that the proof can not be mistaken for an SM2 signature of
+// another protocol.
+const possessionDomain = "SM2RSIGN-V01-POP"
+
+// possessionDigest returns the SM3 digest SM3(ZA || possessionDomain ||
+// ringID) signed by the proof of possession of pub with the user ID uid, or
+// the default user ID if uid is empty.
+func possessionDigest(pub *ecdsa.PublicKey, uid, ringID []byte) ([]byte, error) {
+	msg := make([]byte, 0, len(possessionDomain)+len(ringID))
+	msg = append(msg, possessionDomain...)
+	msg = append(msg, ringID...)
+	return calculateSM2Hash(pub, msg, uid)
+}
+
+// ProvePossession returns the proof that the owner of priv holds the
+// private key of a member of the ring with the given ID, see Ring.ID. The
+// proof is an ASN.1 encoded SM2 signature with the user ID uid of the member
+// over "SM2RSIGN-V01-POP" followed by the ring ID, so it can also be made
+// by any SM2 implementation which signs with a user ID, e.g. a token.
+//
+// The ring ID covers all the members, so the proofs can only be made once
+// the members are known, e.g. by building the ring without
+// WithProofOfPossession first and publishing its ID.
+func ProvePossession(rand io.Reader, priv *sm2.PrivateKey, uid, ringID []byte) ([]byte, error) {
+	if err := checkPrivateKey(priv); err != nil {
+		return nil, err
+	}
+	digest, err := possessionDigest(&priv.PublicKey, uid, ringID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidUID, err)
+	}
+	return sm2.SignASN1(rand, priv, digest, nil)
+}
+
+// VerifyPossession reports whether proof is a valid proof of possession of
+// the private key of pub, with the user ID uid, for the ring with the given
+// ID, see ProvePossession.
+func VerifyPossession(pub *ecdsa.PublicKey, uid, ringID, proof []byte) bool {
+	if checkPublicKey(pub) != nil {
+		return false
+	}
+	digest, err := possessionDigest(pub, uid, ringID)
+	if err != nil {
+		return false
+	}
+	return sm2.VerifyASN1(pub, digest, proof)
+}
+
+// verifyProofs checks the proofs of possession of the members of r, which
+// are in the order the members were supplied in; order maps the position in
+// the ring to that order. It returns an error wrapping ErrInvalidProof and
+// naming the first member whose proof is missing or invalid.
+func (r *Ring) verifyProofs(proofs [][]byte, order []int) error {
+	id := r.ID()
+	for i, pub := range r.keys {
+		j := order[i]
+		if !VerifyPossession(pub, r.UID(i), id, proofs[j]) {
+			return fmt.Errorf("%w: member %d", ErrInvalidProof, j)
+		}
+	}
+	return nil
+}
+
+// Proven reports whether the members of r have proven the possession of
+// their private keys when r was built, see WithProofOfPossession.
+func (r *Ring) Proven() bool {
+	return r.proven
+}
+
+// RequireProvenRing makes linkable signers and verifiers refuse rings which
+// were not built with WithProofOfPossession, with an error wrapping
+// ErrRingNotProven. A member who chose their key after seeing the others,
+// e.g. to cancel them out of the default Hp, can not prove possession of it.
+func RequireProvenRing() LinkableOption {
+	return func(o *linkableOptions) {
+		o.requireProven = true
+	}
+}
+
+// checkProven returns ErrRingNotProven if the options require a proven ring
+// and ring is not.
+func (o *linkableOptions) checkProven(ring *Ring) error {
+	if o.requireProven && !ring.proven {
+		return ErrRingNotProven
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package sm2rsign
+
+import (
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/emmansun/gmsm/sm2"
+)
+
+func TestProvePossession(t *testing.T) {
+	key, _ := sm2.GenerateKey(rand.Reader)
+	other, _ := sm2.GenerateKey(rand.Reader)
+	uid := []byte("alice@example.com")
+	id := RingID(nil)
+
+	proof, err := ProvePossession(rand.Reader, key, uid, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !VerifyPossession(&key.PublicKey, uid, id, proof) {
+		t.Fatal("valid proof rejected")
+	}
+	// the proof is a plain SM2 signature with the user ID
+	if !sm2.VerifyASN1WithSM2(&key.PublicKey, uid, append([]byte(possessionDomain), id...), proof) {
+		t.Error("proof is not an SM2 signature of the domain and the ring ID")
+	}
+	if VerifyPossession(&key.PublicKey, nil, id, proof) {
+		t.Error("proof accepted with another user ID")
+	}
+	if VerifyPossession(&key.PublicKey, uid, RingID(nil)[1:], proof) {
+		t.Error("proof accepted for another ring")
+	}
+	if VerifyPossession(&other.PublicKey, uid, id, proof) {
+		t.Error("proof accepted for another key")
+	}
+	if VerifyPossession(nil, uid, id, proof) || VerifyPossession(&key.PublicKey, uid, id, nil) {
+		t.Error("malformed input accepted")
+	}
+	if _, err := ProvePossession(rand.Reader, key, make([]byte, maxUIDLen+1), id); !errors.Is(err, ErrInvalidUID) {
+		t.Errorf("got %v, want ErrInvalidUID", err)
+	}
+}
+
+func TestRingProofOfPossession(t *testing.T) {
+	keys := make([]*sm2.PrivateKey, 3)
+	members := make([]RingMember, len(keys))
+	for i := range keys {
+		keys[i], _ = sm2.GenerateKey(rand.Reader)
+		members[i] = RingMember{PublicKey: &keys[i].PublicKey}
+	}
+	members[1].UID = []byte("bob@example.com")
+
+	// the members agree on the ring, then prove possession for its ID
+	unproven, err := NewRingWithMembers(members, WithCanonicalOrder(), WithZABinding())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unproven.Proven() {
+		t.Fatal("ring without proofs is proven")
+	}
+	for i := range members {
+		members[i].Proof, err = ProvePossession(rand.Reader, keys[i], members[i].UID, unproven.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	ring, err := NewRingWithMembers(members, WithCanonicalOrder(), WithZABinding(), WithProofOfPossession())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ring.Proven() {
+		t.Fatal("ring with proofs is not proven")
+	}
+	if string(ring.ID()) != string(unproven.ID()) {
+		t.Fatal("WithProofOfPossession changed the ring ID")
+	}
+
+	msg := []byte("proven ring")
+	signer := NewBaseLinkableSignerWithRing(keys[2], ring, RequireProvenRing())
+	sig, err := signer.Sign(rand.Reader, SimpleParticipantRandInt, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewBaseLinkableVerfierWithRing(ring, RequireProvenRing()).VerifyWithError(msg, sig); err != nil {
+		t.Fatal(err)
+	}
+
+	// the same ring without proofs is refused by all schemes
+	if err := NewBaseLinkableVerfierWithRing(unproven, RequireProvenRing()).VerifyWithError(msg, sig); !errors.Is(err, ErrRingNotProven) {
+		t.Errorf("verify: got %v, want ErrRingNotProven", err)
+	}
+	signers := map[string]RingSigner{
+		"base":     NewBaseLinkableSignerWithRing(keys[0], unproven, RequireProvenRing()),
+		"variant1": NewLinkableSignerVariant1WithRing(keys[0], unproven, RequireProvenRing()),
+		"variant2": NewLinkableSignerVariant2WithRing(keys[0], unproven, RequireProvenRing()),
+		"scoped":   NewScopedLinkableSignerWithRing(keys[0], unproven, []byte("scope"), RequireProvenRing()),
+	}
+	for name, signer := range signers {
+		if _, err := signer.Sign(rand.Reader, SimpleParticipantRandInt, msg); !errors.Is(err, ErrRingNotProven) {
+			t.Errorf("%s: got %v, want ErrRingNotProven", name, err)
+		}
+	}
+
+	// invalid and missing proofs name the member in the order supplied
+	bad := append([]RingMember{}, members...)
+	bad[1].Proof = members[0].Proof
+	if _, err := NewRingWithMembers(bad, WithCanonicalOrder(), WithZABinding(), WithProofOfPossession()); !errors.Is(err, ErrInvalidProof) || !strings.Contains(err.Error(), "member 1") {
+		t.Errorf("swapped proof: got %v, want ErrInvalidProof for member 1", err)
+	}
+	bad = append([]RingMember{}, members...)
+	bad[2].Proof = nil
+	if _, err := NewRingWithMembers(bad, WithCanonicalOrder(), WithZABinding(), WithProofOfPossession()); !errors.Is(err, ErrInvalidProof) || !strings.Contains(err.Error(), "member 2") {
+		t.Errorf("missing proof: got %v, want ErrInvalidProof for member 2", err)
+	}
+	// the proofs are bound to the ring, and the ZA binding changes its ID
+	if _, err := NewRingWithMembers(members, WithCanonicalOrder(), WithProofOfPossession()); !errors.Is(err, ErrInvalidProof) {
+		t.Errorf("other ring: got %v, want ErrInvalidProof", err)
+	}
+}
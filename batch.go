@@ -0,0 +1,323 @@
+package sm2rsign
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Batch verification failures.
+var (
+	// ErrBatchAborted is reported for the items a BatchVerifier did not
+	// verify because another item failed, see WithFailFast.
+	ErrBatchAborted = errors.New("sm2rsign: batch verification aborted")
+	// ErrInvalidSignature is reported for the items whose RingVerifier does
+	// not explain why verification failed.
+	ErrInvalidSignature = errors.New("sm2rsign: invalid ring signature")
+)
+
+const (
+	// batchPrepareThreshold is the number of signatures over a ring from
+	// which a BatchVerifier prepares its tables. A member table takes about
+	// 1 ms to compute and saves about 60 µs per signature.
+	batchPrepareThreshold = 16
+	// batchPrepareMaxMembers is the default size of the largest ring whose
+	// tables a BatchVerifier prepares, about 14 MB of tables.
+	batchPrepareMaxMembers = 256
+)
+
+// BatchOption configures a BatchVerifier.
+type BatchOption func(*batchOptions)
+
+type batchOptions struct {
+	workers     int
+	failFast    bool
+	policy      *VerifierPolicy
+	maxPrepared int
+}
+
+// WithWorkers bounds the number of goroutines verifying a batch. It defaults
+// to runtime.GOMAXPROCS(0).
+func WithWorkers(n int) BatchOption {
+	return func(o *batchOptions) {
+		o.workers = n
+	}
+}
+
+// WithFailFast stops the verification of a batch at the first failure. The
+// items which were not verified yet are reported with ErrBatchAborted.
+func WithFailFast() BatchOption {
+	return func(o *batchOptions) {
+		o.failFast = true
+	}
+}
+
+// WithMaxPreparedRingSize bounds the size of the rings whose tables a
+// BatchVerifier prepares by itself, see NewPreparedRing. The tables take
+// about 1 ms to compute and 53 KB of memory per member. It defaults to 256
+// members; zero or less turns the preparation off. The tables of the
+// PreparedRings of the items are shared whatever their size.
+func WithMaxPreparedRingSize(n int) BatchOption {
+	return func(o *batchOptions) {
+		o.maxPrepared = n
+	}
+}
+
+// WithPolicy verifies every item of a batch within the limits of policy,
+// like the methods of VerifierPolicy: the sizes are checked before the ring
+// of the item is hashed, any curve arithmetic is done or any table of the
+// ring is prepared, and the timeout applies to each item.
+func WithPolicy(policy VerifierPolicy) BatchOption {
+	return func(o *batchOptions) {
+		o.policy = &policy
+	}
+}
+
+// preparableVerifier is implemented by the linkable verifiers of this
+// package, so that a BatchVerifier can share the tables of their ring.
+type preparableVerifier interface {
+	ContextVerifier
+	// preparation returns the ring of the verifier and its tables, which may
+	// be nil.
+	preparation() (*Ring, *PreparedRing)
+	// withPrepared returns a copy of the verifier which uses the tables of
+	// pr, which were prepared for a ring with the same ID.
+	withPrepared(pr *PreparedRing) preparableVerifier
+}
+
+type batchItem struct {
+	ring     *Ring
+	prepared *PreparedRing
+	// linkable signatures are verified by verifier, plain ones with
+	// verifyRing
+	linkable bool
+	verifier RingVerifier
+	msg      []byte
+	plain    *RingSignature
+	lsig     *LinkableRingSignature
+}
+
+// BatchVerifier verifies many plain and linkable ring signatures across a
+// bounded pool of goroutines. Signatures over the same ring share its
+// precomputed tables, see PreparedRing: they are computed once enough
+// signatures of a batch use the ring, or taken from the PreparedRing of an
+// item. Rings are matched by their ID, see Ring.ID.
+//
+// A BatchVerifier is not safe for concurrent use. The added messages and
+// signatures must not be modified until Verify returns.
+type BatchVerifier struct {
+	batchOptions
+	items []batchItem
+}
+
+// NewBatchVerifier creates an empty batch.
+func NewBatchVerifier(opts ...BatchOption) *BatchVerifier {
+	b := &BatchVerifier{batchOptions: batchOptions{maxPrepared: batchPrepareMaxMembers}}
+	for _, opt := range opts {
+		opt(&b.batchOptions)
+	}
+	return b
+}
+
+// Len returns the number of items in the batch.
+func (b *BatchVerifier) Len() int {
+	return len(b.items)
+}
+
+// Add adds a ring signature to be verified like Verify, over a copy of pubs.
+func (b *BatchVerifier) Add(pubs []*ecdsa.PublicKey, msg []byte, signature *RingSignature) {
+	b.items = append(b.items, batchItem{ring: newRing(pubs), msg: msg, plain: signature})
+}
+
+// AddRing adds a ring signature to be verified like VerifyRing.
+func (b *BatchVerifier) AddRing(ring *Ring, msg []byte, signature *RingSignature) {
+	b.items = append(b.items, batchItem{ring: ring, msg: msg, plain: signature})
+}
+
+// AddPrepared adds a ring signature to be verified like VerifyPrepared. The
+// tables of ring are also used by the other items over the same ring.
+func (b *BatchVerifier) AddPrepared(ring *PreparedRing, msg []byte, signature *RingSignature) {
+	item := batchItem{prepared: ring, msg: msg, plain: signature}
+	if ring != nil {
+		item.ring = ring.ring
+	}
+	b.items = append(b.items, item)
+}
+
+// AddLinkable adds a linkable ring signature to be verified by v, which may
+// be any RingVerifier. The verifiers of this package share the tables of
+// their ring with the other items, and report why verification failed like
+// their VerifyWithError method. Other verifiers report their own errors if
+// they implement ContextVerifier, and ErrInvalidSignature otherwise.
+func (b *BatchVerifier) AddLinkable(v RingVerifier, msg []byte, signature *LinkableRingSignature) {
+	item := batchItem{linkable: true, verifier: v, msg: msg, lsig: signature}
+	if pv, ok := v.(preparableVerifier); ok {
+		item.ring, item.prepared = pv.preparation()
+	}
+	b.items = append(b.items, item)
+}
+
+// batchTables are the tables shared by the items over rings with an ID.
+type batchTables struct {
+	ring *Ring
+	// prepare is set if the ring is small enough to be prepared
+	prepare bool
+	count   int
+	once    sync.Once
+	pr      *PreparedRing
+}
+
+// get returns the tables, preparing them on first use if enough items use
+// the ring. It returns nil if t is nil or the ring is not worth preparing.
+func (t *batchTables) get() *PreparedRing {
+	if t == nil {
+		return nil
+	}
+	t.once.Do(func() {
+		if t.pr == nil && t.prepare && t.count >= batchPrepareThreshold {
+			// an invalid ring is reported by the verification instead
+			t.pr, _ = NewPreparedRing(t.ring)
+		}
+	})
+	return t.pr
+}
+
+// tables groups the items by the ID of their ring. The items which already
+// failed, those with a non-nil result, are skipped so that their ring is
+// not hashed; results may be nil if none did.
+func (b *BatchVerifier) tables(results []error) map[string]*batchTables {
+	tables := make(map[string]*batchTables)
+	for i, item := range b.items {
+		if item.ring == nil || results != nil && results[i] != nil {
+			continue
+		}
+		id := string(item.ring.ID())
+		t, ok := tables[id]
+		if !ok {
+			t = &batchTables{ring: item.ring, prepare: item.ring.Len() <= b.maxPrepared}
+			tables[id] = t
+		}
+		t.count++
+		if t.pr == nil && item.prepared != nil {
+			t.pr = item.prepared
+		}
+	}
+	return tables
+}
+
+// Verify verifies all the items and returns their results in the order they
+// were added: nil if the signature is valid, and otherwise the error the
+// verification function of the item would have returned. The batch can be
+// verified again, or extended and then verified again.
+func (b *BatchVerifier) Verify() []error {
+	return b.VerifyContext(context.Background())
+}
+
+// VerifyContext is like Verify, but stops if ctx is done. The items which
+// were not verified by then are reported with ctx.Err(). Verifiers of other
+// packages are only cancelled if they implement ContextVerifier.
+func (b *BatchVerifier) VerifyContext(ctx context.Context) []error {
+	results := make([]error, len(b.items))
+	if b.policy != nil {
+		// before the rings are hashed to group the items
+		for i := range b.items {
+			results[i] = b.policy.check(b.items[i].sizes())
+		}
+	}
+	tables := b.tables(results)
+	workers := b.workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	workers = min(workers, len(b.items))
+
+	var next atomic.Int64
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= len(b.items) {
+					return
+				}
+				if results[i] != nil {
+					// rejected by the policy
+					failed.Store(true)
+					continue
+				}
+				if b.failFast && failed.Load() {
+					results[i] = ErrBatchAborted
+					continue
+				}
+				if err := ctx.Err(); err != nil {
+					results[i] = err
+					continue
+				}
+				if results[i] = b.verifyItem(ctx, &b.items[i], tables); results[i] != nil {
+					failed.Store(true)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// verifyItem verifies item with the tables shared by the items over its
+// ring, within the timeout of the policy of the batch, if any.
+func (b *BatchVerifier) verifyItem(ctx context.Context, item *batchItem, tables map[string]*batchTables) error {
+	if b.policy != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = b.policy.context(ctx)
+		defer cancel()
+	}
+	var pr *PreparedRing
+	if item.ring != nil {
+		pr = tables[string(item.ring.ID())].get()
+	}
+	return item.verify(ctx, pr)
+}
+
+// sizes returns the sizes of the ring, the signature and the message of the
+// item, see VerifierPolicy. The ring of verifiers of other packages is not
+// known, its size is 0.
+func (item *batchItem) sizes() (ringSize, signatureSize, msgSize int) {
+	if item.ring != nil {
+		ringSize = item.ring.Len()
+	}
+	if item.linkable {
+		signatureSize = item.lsig.RingSize()
+	} else {
+		signatureSize = item.plain.RingSize()
+	}
+	return ringSize, signatureSize, len(item.msg)
+}
+
+// verify verifies the item using the tables pr, which may be nil.
+func (item *batchItem) verify(ctx context.Context, pr *PreparedRing) error {
+	if !item.linkable {
+		if item.ring == nil {
+			return ErrRingTooSmall
+		}
+		return verifyRing(ctx, item.ring, pr, item.msg, item.plain)
+	}
+	if pv, ok := item.verifier.(preparableVerifier); ok {
+		if pr != nil {
+			pv = pv.withPrepared(pr)
+		}
+		return pv.VerifyContext(ctx, item.msg, item.lsig)
+	}
+	if cv, ok := item.verifier.(ContextVerifier); ok {
+		return cv.VerifyContext(ctx, item.msg, item.lsig)
+	}
+	if item.verifier == nil || !item.verifier.Verify(item.msg, item.lsig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
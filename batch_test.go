@@ -0,0 +1,177 @@
+package sm2rsign
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// plainVerifier is a RingVerifier which is not part of this package.
+type plainVerifier struct {
+	v RingVerifier
+}
+
+func (p plainVerifier) Verify(msg []byte, signature *LinkableRingSignature) bool {
+	return p.v.Verify(msg, signature)
+}
+
+func TestBatchVerifier(t *testing.T) {
+	keys, ring := newTestRing(t, 3)
+	pr, err := NewPreparedRing(ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("batch")
+	wrong := []byte("wrong message")
+
+	sig, err := SignRing(rand.Reader, SimpleParticipantRandInt, keys[0], ring, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	linkable := []struct {
+		signer   RingSigner
+		verifier RingVerifier
+	}{
+		{NewBaseLinkableSignerWithRing(keys[1], ring), NewBaseLinkableVerfierWithRing(ring)},
+		{NewLinkableSignerVariant1WithRing(keys[1], ring), NewLinkableVerfierVariant1WithPreparedRing(pr)},
+		{NewLinkableSignerVariant2WithRing(keys[1], ring, WithHashToCurve()), NewLinkableVerfierVariant2WithRing(ring, WithHashToCurve())},
+		{NewScopedLinkableSignerWithRing(keys[2], ring, []byte("scope")), NewScopedLinkableVerfierWithRing(ring, []byte("scope"))},
+		{NewBaseLinkableSignerWithRing(keys[2], ring), plainVerifier{NewBaseLinkableVerfierWithRing(ring)}},
+	}
+
+	b := NewBatchVerifier(WithWorkers(3))
+	var want []error
+	add := func(err error) {
+		want = append(want, err)
+	}
+	b.Add(ring.PublicKeys(), msg, sig)
+	add(nil)
+	b.AddRing(ring, wrong, sig)
+	add(ErrRingEquation)
+	b.AddPrepared(pr, msg, sig)
+	add(nil)
+	b.AddRing(nil, msg, sig)
+	add(ErrRingTooSmall)
+	b.Add([]*ecdsa.PublicKey{ring.PublicKey(0)}, msg, sig)
+	add(ErrRingTooSmall)
+	for _, tt := range linkable {
+		lsig, err := tt.signer.Sign(rand.Reader, SimpleParticipantRandInt, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.AddLinkable(tt.verifier, msg, lsig)
+		add(nil)
+		b.AddLinkable(tt.verifier, wrong, lsig)
+		if _, ok := tt.verifier.(plainVerifier); ok {
+			add(ErrInvalidSignature)
+		} else {
+			add(ErrRingEquation)
+		}
+	}
+	b.AddLinkable(nil, msg, nil)
+	add(ErrInvalidSignature)
+
+	if b.Len() != len(want) {
+		t.Fatalf("Len() = %d, want %d", b.Len(), len(want))
+	}
+	// a batch can be verified more than once
+	for range 2 {
+		results := b.Verify()
+		if len(results) != len(want) {
+			t.Fatalf("got %d results, want %d", len(results), len(want))
+		}
+		for i, err := range results {
+			if !errors.Is(err, want[i]) {
+				t.Errorf("item %d: got %v, want %v", i, err, want[i])
+			}
+		}
+	}
+
+	if results := NewBatchVerifier().Verify(); len(results) != 0 {
+		t.Errorf("empty batch: got %d results", len(results))
+	}
+}
+
+func TestBatchVerifierSharedTables(t *testing.T) {
+	keys, ring := newTestRing(t, 2)
+	other, err := NewRing(ring.PublicKeys())
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("shared tables")
+	b := NewBatchVerifier()
+	for i := 0; i < batchPrepareThreshold; i++ {
+		// rings with the same ID share the tables, whatever the scheme
+		if i%2 == 0 {
+			sig, err := SignRing(rand.Reader, SimpleParticipantRandInt, keys[0], ring, msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b.AddRing(ring, msg, sig)
+		} else {
+			sig, err := NewBaseLinkableSignerWithRing(keys[1], other).Sign(rand.Reader, SimpleParticipantRandInt, msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b.AddLinkable(NewBaseLinkableVerfierWithRing(other), msg, sig)
+		}
+	}
+	tables := b.tables(nil)
+	if len(tables) != 1 {
+		t.Fatalf("got %d rings, want 1", len(tables))
+	}
+	if pr := tables[string(ring.ID())].get(); pr == nil {
+		t.Fatal("tables of a ring with many signatures were not prepared")
+	}
+	for i, err := range b.Verify() {
+		if err != nil {
+			t.Errorf("item %d: %v", i, err)
+		}
+	}
+
+	// a single signature does not prepare the ring
+	b = NewBatchVerifier()
+	b.AddRing(ring, msg, nil)
+	if pr := b.tables(nil)[string(ring.ID())].get(); pr != nil {
+		t.Error("tables prepared for a single signature")
+	}
+
+	// neither do rings above the size limit, or any ring if it is off
+	for _, n := range []int{1, 0} {
+		b = NewBatchVerifier(WithMaxPreparedRingSize(n))
+		for i := 0; i < batchPrepareThreshold; i++ {
+			b.AddRing(ring, msg, nil)
+		}
+		if pr := b.tables(nil)[string(ring.ID())].get(); pr != nil {
+			t.Errorf("limit %d: tables prepared for a ring of %d members", n, ring.Len())
+		}
+	}
+}
+
+func TestBatchVerifierFailFast(t *testing.T) {
+	keys, ring := newTestRing(t, 2)
+	msg := []byte("fail fast")
+	sig, err := SignRing(rand.Reader, SimpleParticipantRandInt, keys[0], ring, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewBatchVerifier(WithWorkers(1), WithFailFast())
+	b.AddRing(ring, msg, sig)
+	b.AddRing(ring, []byte("wrong message"), sig)
+	for range 3 {
+		b.AddRing(ring, msg, sig)
+	}
+	results := b.Verify()
+	if results[0] != nil {
+		t.Errorf("item 0: %v", results[0])
+	}
+	if !errors.Is(results[1], ErrRingEquation) {
+		t.Errorf("item 1: got %v, want ErrRingEquation", results[1])
+	}
+	for i, err := range results[2:] {
+		if !errors.Is(err, ErrBatchAborted) {
+			t.Errorf("item %d: got %v, want ErrBatchAborted", i+2, err)
+		}
+	}
+}
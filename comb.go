@@ -0,0 +1,113 @@
+package sm2rsign
+
+import (
+	"math/big"
+	"sync"
+
+	"github.com/emmansun/gmsm/sm2"
+)
+
+const (
+	// memberTableWidth is the window width of the tables of the ring
+	// members and of other per-ring points, 52 mixed additions per scalar
+	// multiplication for about 53 KB per table.
+	memberTableWidth = 5
+	// baseTableWidth is the window width of the table of the generator,
+	// which is shared by all rings: 33 mixed additions for about 270 KB.
+	baseTableWidth = 8
+)
+
+// combTable is a fixed-base table of a point P for signed windows of w
+// bits: entry 2^(w-1)·j + k is (k+1)·2^(w·j)·P for k in [0, 2^(w-1)). A
+// scalar recoded into signed digits in [-2^(w-1), 2^(w-1)] is multiplied by
+// P with at most one mixed addition per digit and no doublings.
+//
+// Tables are only used with public scalars, the lookups are not constant
+// time.
+type combTable struct {
+	w      uint
+	points []affinePoint
+}
+
+// baseTable returns the table of the generator G.
+var baseTable = sync.OnceValue(func() *combTable {
+	params := sm2.P256().Params()
+	g, _ := newAffinePoint(params.Gx, params.Gy)
+	return newCombTable(g, baseTableWidth)
+})
+
+// combWindows returns the number of signed digits of a scalar < 2^256.
+func combWindows(w uint) int {
+	return 256/int(w) + 1
+}
+
+// newCombTable returns the table of p for windows of w bits.
+func newCombTable(p *affinePoint, w uint) *combTable {
+	windows, size := combWindows(w), 1<<(w-1)
+	points := make([]point, 0, windows*size)
+	var base point
+	base.setAffine(p)
+	for j := 0; j < windows; j++ {
+		var q point
+		q.double(&base)
+		points = append(points, base, q)
+		for k := 2; k < size; k++ {
+			q.add(&q, &base)
+			points = append(points, q)
+		}
+		// 2^w·base = 2·(2^(w-1)·base)
+		base.double(&q)
+	}
+	return &combTable{w: w, points: batchAffine(points)}
+}
+
+// mulAdd sets acc = acc + k·P for k in [0, 2^256).
+func (t *combTable) mulAdd(acc *point, k *big.Int) {
+	if t == nil {
+		// the table of the point at infinity
+		return
+	}
+	var b [32]byte
+	k.FillBytes(b[:])
+	size := 1 << (t.w - 1)
+	var neg affinePoint
+	carry := 0
+	for j := 0; j < combWindows(t.w); j++ {
+		d := scalarWindow(&b, uint(j)*t.w, t.w) + carry
+		carry = 0
+		if d > size {
+			d -= 2 * size
+			carry = 1
+		}
+		switch {
+		case d > 0:
+			acc.addAffine(acc, &t.points[j*size+d-1])
+		case d < 0:
+			entry := &t.points[j*size-d-1]
+			neg.x = entry.x
+			neg.y.Neg(&entry.y)
+			acc.addAffine(acc, &neg)
+		}
+	}
+}
+
+// addTo sets acc = acc + P.
+func (t *combTable) addTo(acc *point) {
+	if t != nil {
+		acc.addAffine(acc, &t.points[0])
+	}
+}
+
+// scalarWindow returns the w bits of the 32-byte big-endian scalar b
+// starting at bit offset, which may extend past the top bit.
+func scalarWindow(b *[32]byte, offset, w uint) int {
+	var v uint
+	for i := uint(0); i < w; i++ {
+		bit := offset + i
+		if bit >= 256 {
+			break
+		}
+		v |= uint(b[31-bit/8]>>(bit%8)&1) << i
+	}
+	return int(v)
+}
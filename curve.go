@@ -0,0 +1,112 @@
+package sm2rsign
+
+import (
+	cryptorand "crypto/rand"
+	"math/big"
+
+	"github.com/emmansun/gmsm/sm2"
+)
+
+// gmsm implements the scalar multiplications of the SM2 curve with constant
+// time nistec-style points, in assembly on the main platforms, but only
+// exposes them through the deprecated crypto/elliptic interface, which
+// represents the point at infinity as (0, 0). The functions below are the
+// only users of that interface in this package: they take the point types
+// of point.go, whose point at infinity is explicit. All other curve
+// arithmetic is done with those types.
+
+// scalarBaseMult sets p = k·G for k in [0, N). It runs in constant time, so
+// k may be secret.
+func (p *point) scalarBaseMult(k *big.Int) *point {
+	var b [32]byte
+	return p.setBig(sm2.P256().ScalarBaseMult(k.FillBytes(b[:])))
+}
+
+// scalarMult sets p = k·q for k in [0, N). It runs in constant time, so k
+// may be secret.
+func (p *point) scalarMult(q *affinePoint, k *big.Int) *point {
+	var b [32]byte
+	x, y := q.Big()
+	return p.setBig(sm2.P256().ScalarMult(x, y, k.FillBytes(b[:])))
+}
+
+// p256Combined is the CombinedMult method of gmsm's SM2 curve.
+var p256Combined = sm2.P256().(interface {
+	CombinedMult(Px, Py *big.Int, s1, s2 []byte) (x, y *big.Int)
+})
+
+// combinedMult returns the affine coordinates of s·G + c·(qx, qy) for s and
+// c in [0, N), or (0, 0) for the point at infinity. (qx, qy) must be a point
+// on the curve. Both multiplications and the addition stay in gmsm's point
+// type, with a single conversion to affine coordinates, which is faster
+// than adding a multiplication of the point to the table of G. Without a
+// table of the point, it is the fastest way to compute a ring step.
+func combinedMult(qx, qy, s, c *big.Int) (x, y *big.Int) {
+	var sb, cb [32]byte
+	return p256Combined.CombinedMult(qx, qy, s.FillBytes(sb[:]), c.FillBytes(cb[:]))
+}
+
+// scalarMultSecret sets p = k·q, or p = k·G if q is nil, for a secret k in
+// [0, N). As a countermeasure against side channels that the constant time
+// multiplication does not cover, such as power analysis, k is blinded: it
+// is split into a random share r, read from crypto/rand, and k - r, and p is
+// computed as r·q + (k - r)·q, so neither multiplication sees k itself.
+func (p *point) scalarMultSecret(q *affinePoint, k *big.Int) (*point, error) {
+	var ks, r scalar
+	defer func() {
+		clear(ks[:])
+		clear(r[:])
+	}()
+	if err := randScalar(&r, cryptorand.Reader); err != nil {
+		return nil, err
+	}
+	if !ks.SetBig(k) {
+		return nil, ErrInvalidPrivateKey
+	}
+	var b [32]byte
+	defer clear(b[:])
+	share := new(big.Int)
+	defer zeroBig(share)
+	var t point
+	p.setInfinity()
+	for _, v := range []*scalar{&r, ks.Sub(&ks, &r)} {
+		share.SetBytes(v.FillBytes(b[:]))
+		if q == nil {
+			t.scalarBaseMult(share)
+		} else {
+			t.scalarMult(q, share)
+		}
+		p.add(p, &t)
+	}
+	return p, nil
+}
+
+// secretMult returns the affine coordinates of k·q, or k·G if q is nil, for
+// a secret k, see scalarMultSecret. The result itself must be public, such
+// as a key image or the point of the signer's step.
+func secretMult(q *affinePoint, k *big.Int) (x, y *big.Int, err error) {
+	var p point
+	if _, err := p.scalarMultSecret(q, k); err != nil {
+		return nil, nil, err
+	}
+	x, y = p.affine()
+	return x, y, nil
+}
+
+// p256G is the generator of the SM2 curve.
+var p256G = func() *affinePoint {
+	params := sm2.P256().Params()
+	g, _ := newAffinePoint(params.Gx, params.Gy)
+	return g
+}()
+
+// basePoint returns the linkability base point (x, y) of a ring, which is
+// (0, 0) if it is the point at infinity. It returns ErrInvalidBasePoint in
+// that case, since no key image can be derived from it.
+func basePoint(x, y *big.Int) (*affinePoint, error) {
+	p, ok := newAffinePoint(x, y)
+	if !ok {
+		return nil, ErrInvalidBasePoint
+	}
+	return p, nil
+}
@@ -0,0 +1,308 @@
+package sm2rsign
+
+import (
+	"math/big"
+	"math/bits"
+)
+
+// fieldElement is an element of the SM2 base field GF(p) in the Montgomery
+// domain, i.e. x·R mod p with R = 2^256, as four little-endian 64-bit limbs.
+// All operations are constant time.
+type fieldElement [4]uint64
+
+// p256P is the SM2 base field prime 2^256 - 2^224 - 2^96 + 2^64 - 1.
+var p256P = [4]uint64{0xffffffffffffffff, 0xffffffff00000000, 0xffffffffffffffff, 0xfffffffeffffffff}
+
+var (
+	// feOne is 1 in the Montgomery domain, R mod p.
+	feOne = fieldElement{0x0000000000000001, 0x00000000ffffffff, 0x0000000000000000, 0x0000000100000000}
+	// feRR is R^2 mod p, used to convert into the Montgomery domain.
+	feRR = fieldElement{0x0000000200000003, 0x00000002ffffffff, 0x0000000100000001, 0x0000000400000002}
+)
+
+// montMul sets z = x·y·R^-1 mod m, where m is odd, x, y < m and
+// inv = -m^-1 mod 2^64. z may alias x or y.
+func montMul(z, x, y, m *[4]uint64, inv uint64) {
+	var t0, t1, t2, t3, t4 uint64
+	for i := 0; i < 4; i++ {
+		// t += x·y[i]
+		var c, hi, lo uint64
+		yi := y[i]
+		hi, lo = bits.Mul64(x[0], yi)
+		t0, c = bits.Add64(t0, lo, 0)
+		carry := hi + c
+		hi, lo = bits.Mul64(x[1], yi)
+		lo, c = bits.Add64(lo, carry, 0)
+		hi += c
+		t1, c = bits.Add64(t1, lo, 0)
+		carry = hi + c
+		hi, lo = bits.Mul64(x[2], yi)
+		lo, c = bits.Add64(lo, carry, 0)
+		hi += c
+		t2, c = bits.Add64(t2, lo, 0)
+		carry = hi + c
+		hi, lo = bits.Mul64(x[3], yi)
+		lo, c = bits.Add64(lo, carry, 0)
+		hi += c
+		t3, c = bits.Add64(t3, lo, 0)
+		carry = hi + c
+		var t5 uint64
+		t4, t5 = bits.Add64(t4, carry, 0)
+
+		// t = (t + u·m) / 2^64, with u chosen so that the low limb vanishes
+		u := t0 * inv
+		hi, lo = bits.Mul64(u, m[0])
+		_, c = bits.Add64(t0, lo, 0)
+		carry = hi + c
+		hi, lo = bits.Mul64(u, m[1])
+		lo, c = bits.Add64(lo, carry, 0)
+		hi += c
+		t0, c = bits.Add64(t1, lo, 0)
+		carry = hi + c
+		hi, lo = bits.Mul64(u, m[2])
+		lo, c = bits.Add64(lo, carry, 0)
+		hi += c
+		t1, c = bits.Add64(t2, lo, 0)
+		carry = hi + c
+		hi, lo = bits.Mul64(u, m[3])
+		lo, c = bits.Add64(lo, carry, 0)
+		hi += c
+		t2, c = bits.Add64(t3, lo, 0)
+		carry = hi + c
+		t3, c = bits.Add64(t4, carry, 0)
+		t4 = t5 + c
+	}
+	reduceOnce(z, t0, t1, t2, t3, t4, m)
+}
+
+// reduceOnce sets z = t - m if t >= m, or z = t otherwise, where t is the
+// five limb value t0..t4 and t < 2m.
+func reduceOnce(z *[4]uint64, t0, t1, t2, t3, t4 uint64, m *[4]uint64) {
+	var b uint64
+	r0, b := bits.Sub64(t0, m[0], 0)
+	r1, b := bits.Sub64(t1, m[1], b)
+	r2, b := bits.Sub64(t2, m[2], b)
+	r3, b := bits.Sub64(t3, m[3], b)
+	_, b = bits.Sub64(t4, 0, b)
+	// b is 1 iff t < m, keep t then
+	mask := -b
+	z[0] = r0&^mask | t0&mask
+	z[1] = r1&^mask | t1&mask
+	z[2] = r2&^mask | t2&mask
+	z[3] = r3&^mask | t3&mask
+}
+
+// modAdd sets z = x + y mod m, for x, y < m.
+func modAdd(z, x, y, m *[4]uint64) {
+	var c uint64
+	t0, c := bits.Add64(x[0], y[0], 0)
+	t1, c := bits.Add64(x[1], y[1], c)
+	t2, c := bits.Add64(x[2], y[2], c)
+	t3, c := bits.Add64(x[3], y[3], c)
+	reduceOnce(z, t0, t1, t2, t3, c, m)
+}
+
+// modSub sets z = x - y mod m, for x, y < m.
+func modSub(z, x, y, m *[4]uint64) {
+	var b uint64
+	t0, b := bits.Sub64(x[0], y[0], 0)
+	t1, b := bits.Sub64(x[1], y[1], b)
+	t2, b := bits.Sub64(x[2], y[2], b)
+	t3, b := bits.Sub64(x[3], y[3], b)
+	// add m back if the subtraction borrowed
+	mask := -b
+	var c uint64
+	z[0], c = bits.Add64(t0, m[0]&mask, 0)
+	z[1], c = bits.Add64(t1, m[1]&mask, c)
+	z[2], c = bits.Add64(t2, m[2]&mask, c)
+	z[3], _ = bits.Add64(t3, m[3]&mask, c)
+}
+
+// Mul sets z = x·y. It is montMul specialized to p: -p^-1 mod 2^64 is 1,
+// and adding u·p to clear the low limb u adds u·(p+1)/2^64 =
+// u·(2^192 - 2^160 - 2^32 + 1) to the remaining limbs, which needs no
+// multiplications.
+func (z *fieldElement) Mul(x, y *fieldElement) *fieldElement {
+	var t0, t1, t2, t3, t4 uint64
+	for i := 0; i < 4; i++ {
+		// t += x·y[i]
+		var c, hi, lo uint64
+		yi := y[i]
+		hi, lo = bits.Mul64(x[0], yi)
+		t0, c = bits.Add64(t0, lo, 0)
+		carry := hi + c
+		hi, lo = bits.Mul64(x[1], yi)
+		lo, c = bits.Add64(lo, carry, 0)
+		hi += c
+		t1, c = bits.Add64(t1, lo, 0)
+		carry = hi + c
+		hi, lo = bits.Mul64(x[2], yi)
+		lo, c = bits.Add64(lo, carry, 0)
+		hi += c
+		t2, c = bits.Add64(t2, lo, 0)
+		carry = hi + c
+		hi, lo = bits.Mul64(x[3], yi)
+		lo, c = bits.Add64(lo, carry, 0)
+		hi += c
+		t3, c = bits.Add64(t3, lo, 0)
+		carry = hi + c
+		var t5 uint64
+		t4, t5 = bits.Add64(t4, carry, 0)
+
+		// t = (t + t0·p) / 2^64
+		u := t0
+		d0, b := bits.Sub64(u, u<<32, 0)
+		d1, b := bits.Sub64(0, u>>32, b)
+		d2, b := bits.Sub64(0, u<<32, b)
+		d3, _ := bits.Sub64(u, u>>32, b)
+		t0, c = bits.Add64(t1, d0, 0)
+		t1, c = bits.Add64(t2, d1, c)
+		t2, c = bits.Add64(t3, d2, c)
+		t3, c = bits.Add64(t4, d3, c)
+		t4 = t5 + c
+	}
+	reduceOnce((*[4]uint64)(z), t0, t1, t2, t3, t4, &p256P)
+	return z
+}
+
+func (z *fieldElement) Square(x *fieldElement) *fieldElement {
+	return z.Mul(x, x)
+}
+
+func (z *fieldElement) Add(x, y *fieldElement) *fieldElement {
+	modAdd((*[4]uint64)(z), (*[4]uint64)(x), (*[4]uint64)(y), &p256P)
+	return z
+}
+
+func (z *fieldElement) Sub(x, y *fieldElement) *fieldElement {
+	modSub((*[4]uint64)(z), (*[4]uint64)(x), (*[4]uint64)(y), &p256P)
+	return z
+}
+
+func (z *fieldElement) Neg(x *fieldElement) *fieldElement {
+	var zero fieldElement
+	return z.Sub(&zero, x)
+}
+
+// IsZero returns 1 if z = 0, and 0 otherwise.
+func (z *fieldElement) IsZero() int {
+	v := z[0] | z[1] | z[2] | z[3]
+	return int(1 ^ (v|-v)>>63)
+}
+
+// Equal returns 1 if z = x, and 0 otherwise.
+func (z *fieldElement) Equal(x *fieldElement) int {
+	var d fieldElement
+	for i := range d {
+		d[i] = z[i] ^ x[i]
+	}
+	return d.IsZero()
+}
+
+// Select sets z = x if cond = 1, and z = y if cond = 0.
+func (z *fieldElement) Select(x, y *fieldElement, cond int) *fieldElement {
+	mask := -uint64(cond)
+	for i := range z {
+		z[i] = x[i]&mask | y[i]&^mask
+	}
+	return z
+}
+
+// Invert sets z = x^-1 = x^(p-2) mod p, or 0 if x = 0.
+func (z *fieldElement) Invert(x *fieldElement) *fieldElement {
+	// p - 2 is, from the top bit, 31 ones, a zero, 128 ones, 32 zeros,
+	// 32 ones, 30 ones, a zero and a one. xN below is x^(2^N - 1).
+	var x2, x3, x6, x12, x24, x30, x31, x32, r fieldElement
+	x2.Square(x)
+	x2.Mul(&x2, x)
+	x3.Square(&x2)
+	x3.Mul(&x3, x)
+	x6.squareN(&x3, 3)
+	x6.Mul(&x6, &x3)
+	x12.squareN(&x6, 6)
+	x12.Mul(&x12, &x6)
+	x24.squareN(&x12, 12)
+	x24.Mul(&x24, &x12)
+	x30.squareN(&x24, 6)
+	x30.Mul(&x30, &x6)
+	x31.Square(&x30)
+	x31.Mul(&x31, x)
+	x32.Square(&x31)
+	x32.Mul(&x32, x)
+
+	r.Square(&x31)
+	for i := 0; i < 4; i++ {
+		r.squareN(&r, 32)
+		r.Mul(&r, &x32)
+	}
+	r.squareN(&r, 64)
+	r.Mul(&r, &x32)
+	r.squareN(&r, 30)
+	r.Mul(&r, &x30)
+	r.squareN(&r, 2)
+	z.Mul(&r, x)
+	return z
+}
+
+// squareN sets z = x^(2^n).
+func (z *fieldElement) squareN(x *fieldElement, n int) *fieldElement {
+	z.Square(x)
+	for i := 1; i < n; i++ {
+		z.Square(z)
+	}
+	return z
+}
+
+// SetBytes sets z to the 32-byte big-endian value b, which must be < p. It
+// returns false otherwise.
+func (z *fieldElement) SetBytes(b []byte) bool {
+	var v [4]uint64
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 8; j++ {
+			v[3-i] = v[3-i]<<8 | uint64(b[8*i+j])
+		}
+	}
+	_, borrow := bits.Sub64(v[0], p256P[0], 0)
+	_, borrow = bits.Sub64(v[1], p256P[1], borrow)
+	_, borrow = bits.Sub64(v[2], p256P[2], borrow)
+	_, borrow = bits.Sub64(v[3], p256P[3], borrow)
+	if borrow == 0 {
+		return false
+	}
+	fe := fieldElement(v)
+	z.Mul(&fe, &feRR)
+	return true
+}
+
+// Bytes returns the 32-byte big-endian encoding of z.
+func (z *fieldElement) Bytes() []byte {
+	var out [32]byte
+	return z.FillBytes(out[:])
+}
+
+// FillBytes writes the 32-byte big-endian encoding of z into b.
+func (z *fieldElement) FillBytes(b []byte) []byte {
+	one := fieldElement{1}
+	var v fieldElement
+	v.Mul(z, &one)
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 8; j++ {
+			b[8*i+j] = byte(v[3-i] >> (56 - 8*j))
+		}
+	}
+	return b
+}
+
+// SetBig sets z to x, which must be in [0, p). It returns false otherwise.
+func (z *fieldElement) SetBig(x *big.Int) bool {
+	if x.Sign() < 0 || x.BitLen() > 256 {
+		return false
+	}
+	var b [32]byte
+	return z.SetBytes(x.FillBytes(b[:]))
+}
+
+// Big returns z as a big.Int.
+func (z *fieldElement) Big() *big.Int {
+	return new(big.Int).SetBytes(z.Bytes())
+}
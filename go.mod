@@ -4,6 +4,7 @@ go 1.23.0
 
 toolchain go1.24.0
 
-require github.com/emmansun/gmsm v0.31.0
-
-require golang.org/x/crypto v0.41.0 // indirect
+require (
+	github.com/emmansun/gmsm v0.31.0
+	golang.org/x/crypto v0.41.0
+)
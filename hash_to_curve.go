@@ -0,0 +1,205 @@
+package sm2rsign
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"hash"
+	"math/big"
+
+	"github.com/emmansun/gmsm/sm2"
+	"github.com/emmansun/gmsm/sm3"
+)
+
+// HashToCurveSuite is the RFC 9380 suite identifier of HashToCurve: the SM2
+// curve, expand_message_xmd with SM3, the simplified SWU map and the random
+// oracle encoding.
+const HashToCurveSuite = "SM2P256V1_XMD:SM3_SSWU_RO_"
+
+// ringHashToCurveDST is the domain separation tag used to derive the
+// linkability base point Hp from the ring members.
+const ringHashToCurveDST = "SM2RSIGN-V01-CS01-with-" + HashToCurveSuite
+
+var (
+	// sswuZ is the Z parameter of the simplified SWU map, the output of
+	// find_z_sswu of RFC 9380 appendix H.2 for the SM2 curve, i.e. -9 mod p.
+	sswuZ = new(big.Int).Sub(sm2.P256().Params().P, big.NewInt(9))
+	// sswuA is the curve parameter a = -3 mod p.
+	sswuA = new(big.Int).Sub(sm2.P256().Params().P, big.NewInt(3))
+)
+
+// HashToCurve hashes msg to a point of the SM2 curve with the hash_to_curve
+// construction of RFC 9380 (suite HashToCurveSuite). dst is the domain
+// separation tag and must be 1 to 255 bytes long.
+//
+// The inputs are assumed to be public, the implementation is not constant
+// time.
+func HashToCurve(msg, dst []byte) (x, y *big.Int, err error) {
+	h := newXMDHash()
+	h.Write(msg)
+	return hashToCurveXMD(h, dst)
+}
+
+// hashToCurveXMD is like HashToCurve, with the message already written into
+// h, see newXMDHash.
+func hashToCurveXMD(h hash.Hash, dst []byte) (x, y *big.Int, err error) {
+	u, err := hashToField(h, dst, 2)
+	if err != nil {
+		return nil, nil, err
+	}
+	x0, y0 := mapToCurveSSWU(u[0])
+	x1, y1 := mapToCurveSSWU(u[1])
+	// the cofactor of the SM2 curve is 1, clear_cofactor is a no-op
+	var sum point
+	x, y = sum.setBig(x0, y0).addBig(x1, y1).affine()
+	return x, y, nil
+}
+
+// newXMDHash returns an SM3 hash which has absorbed Z_pad, ready for the
+// message of expand_message_xmd to be written into it. This allows long
+// messages, such as the members of a ring, to be hashed incrementally.
+func newXMDHash() hash.Hash {
+	h := sm3.New()
+	h.Write(make([]byte, sm3.BlockSize))
+	return h
+}
+
+// expandMessageXMD implements expand_message_xmd of RFC 9380 section 5.3.1
+// with SM3.
+func expandMessageXMD(msg, dst []byte, lenInBytes int) ([]byte, error) {
+	h := newXMDHash()
+	h.Write(msg)
+	return expandXMD(h, dst, lenInBytes)
+}
+
+// expandXMD is like expandMessageXMD, with the message already written into
+// h, see newXMDHash. It consumes h.
+func expandXMD(h hash.Hash, dst []byte, lenInBytes int) ([]byte, error) {
+	const bInBytes = sm3.Size
+	ell := (lenInBytes + bInBytes - 1) / bInBytes
+	if ell > 255 || lenInBytes > 65535 || len(dst) == 0 || len(dst) > 255 {
+		return nil, errors.New("sm2rsign: invalid hash to curve parameters")
+	}
+	dstPrime := append(append([]byte{}, dst...), byte(len(dst)))
+
+	h.Write([]byte{byte(lenInBytes >> 8), byte(lenInBytes), 0})
+	h.Write(dstPrime)
+	b0 := h.Sum(nil)
+
+	out := make([]byte, 0, ell*bInBytes)
+	bi := make([]byte, bInBytes)
+	for i := 1; i <= ell; i++ {
+		// b_1 = H(b_0 || 1 || DST'), b_i = H(strxor(b_0, b_(i-1)) || i || DST')
+		for j := range bi {
+			bi[j] ^= b0[j]
+		}
+		h.Reset()
+		h.Write(bi)
+		h.Write([]byte{byte(i)})
+		h.Write(dstPrime)
+		bi = h.Sum(bi[:0])
+		out = append(out, bi...)
+	}
+	return out[:lenInBytes], nil
+}
+
+// hashToField implements hash_to_field of RFC 9380 section 5.2 for the SM2
+// base field, with L = 48 for a 128-bit security level. The message is
+// already written into h, see newXMDHash.
+func hashToField(h hash.Hash, dst []byte, count int) ([]*big.Int, error) {
+	const L = 48
+	uniform, err := expandXMD(h, dst, count*L)
+	if err != nil {
+		return nil, err
+	}
+	p := sm2.P256().Params().P
+	u := make([]*big.Int, count)
+	for i := range u {
+		u[i] = new(big.Int).SetBytes(uniform[i*L : (i+1)*L])
+		u[i].Mod(u[i], p)
+	}
+	return u, nil
+}
+
+// mapToCurveSSWU implements the simplified SWU map of RFC 9380 section 6.6.2.
+func mapToCurveSSWU(u *big.Int) (x, y *big.Int) {
+	params := sm2.P256().Params()
+	p := params.P
+
+	// tv1 = inv0(Z^2 * u^4 + Z * u^2)
+	zu2 := new(big.Int).Mul(u, u)
+	zu2.Mul(zu2, sswuZ)
+	zu2.Mod(zu2, p)
+	tv1 := new(big.Int).Mul(zu2, zu2)
+	tv1.Add(tv1, zu2)
+	tv1.Mod(tv1, p)
+
+	x1 := new(big.Int)
+	if tv1.Sign() == 0 {
+		// x1 = B / (Z * A)
+		x1.Mul(sswuZ, sswuA)
+		x1.ModInverse(x1, p)
+		x1.Mul(x1, params.B)
+	} else {
+		// x1 = (-B / A) * (1 + tv1)
+		tv1.ModInverse(tv1, p)
+		tv1.Add(tv1, one)
+		x1.ModInverse(sswuA, p)
+		x1.Mul(x1, params.B)
+		x1.Neg(x1)
+		x1.Mul(x1, tv1)
+	}
+	x1.Mod(x1, p)
+
+	if y = curveSqrt(curveRHS(x1)); y != nil {
+		x = x1
+	} else {
+		// x2 = Z * u^2 * x1
+		x = x1.Mul(x1, zu2)
+		x.Mod(x, p)
+		y = curveSqrt(curveRHS(x))
+	}
+	if u.Bit(0) != y.Bit(0) {
+		y.Sub(p, y)
+	}
+	return x, y
+}
+
+// curveRHS returns x^3 - 3x + b mod p.
+func curveRHS(x *big.Int) *big.Int {
+	params := sm2.P256().Params()
+	rhs := new(big.Int).Mul(x, x)
+	rhs.Add(rhs, sswuA)
+	rhs.Mul(rhs, x)
+	rhs.Add(rhs, params.B)
+	return rhs.Mod(rhs, params.P)
+}
+
+// curveSqrt returns the square root of a mod p, or nil if a is not a square.
+// The SM2 prime satisfies p = 3 mod 4, so the root is a^((p+1)/4).
+func curveSqrt(a *big.Int) *big.Int {
+	p := sm2.P256().Params().P
+	e := new(big.Int).Add(p, one)
+	e.Rsh(e, 2)
+	y := new(big.Int).Exp(a, e, p)
+	y2 := new(big.Int).Mul(y, y)
+	if y2.Mod(y2, p).Cmp(a) != 0 {
+		return nil
+	}
+	return y
+}
+
+// hashRingToPoint derives the linkability base point Hp by hashing the
+// uncompressed encodings of the ring members, in ring order, to the curve.
+func hashRingToPoint(pubs []*ecdsa.PublicKey) (x, y *big.Int) {
+	h := newXMDHash()
+	for _, pub := range pubs {
+		writeXMDMember(h, pub)
+	}
+	x, y, _ = hashToCurveXMD(h, []byte(ringHashToCurveDST))
+	return
+}
+
+func writeXMDMember(h hash.Hash, pub *ecdsa.PublicKey) {
+	var buffer [1 + 2*scalarSize]byte
+	h.Write(appendUncompressed(buffer[:0], pub.X, pub.Y))
+}
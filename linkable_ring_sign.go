@@ -1,28 +1,162 @@
 package sm2rsign
 
 import (
+	"bytes"
+	"context"
 	"crypto/ecdsa"
+	"fmt"
 	"io"
 	"math/big"
 
 	"github.com/emmansun/gmsm/sm2"
-	"github.com/emmansun/gmsm/sm3"
 )
 
 type RingSigner interface {
-	Sign(rand io.Reader, participantRandInt ParticipantRandInt, msg []byte) ([]*big.Int, error)
+	Sign(rand io.Reader, participantRandInt ParticipantRandInt, msg []byte) (*LinkableRingSignature, error)
 }
 
+// RingVerifier verifies linkable ring signatures. Implementations in this
+// package return false for malformed input and never panic.
 type RingVerifier interface {
-	Verify(msg []byte, signature []*big.Int) bool
+	Verify(msg []byte, signature *LinkableRingSignature) bool
+}
+
+// LinkableScheme identifies the algorithm that produced a linkable ring
+// signature. Signatures of different schemes are not interchangeable.
+type LinkableScheme int
+
+const (
+	LinkableSchemeBase LinkableScheme = iota + 1
+	LinkableSchemeVariant1
+	LinkableSchemeVariant2
+	LinkableSchemeScoped
+)
+
+func (scheme LinkableScheme) String() string {
+	switch scheme {
+	case LinkableSchemeBase:
+		return "base"
+	case LinkableSchemeVariant1:
+		return "variant1"
+	case LinkableSchemeVariant2:
+		return "variant2"
+	case LinkableSchemeScoped:
+		return "scoped"
+	}
+	return "unknown"
+}
+
+func (scheme LinkableScheme) valid() bool {
+	return scheme >= LinkableSchemeBase && scheme <= LinkableSchemeScoped
+}
+
+type schemeVerifier interface {
+	RingVerifier
+	Scheme() LinkableScheme
+}
+
+// verifyLinkableASN1 parses an ASN.1 encoded linkable ring signature and
+// verifies it only if it was produced by the verifier's own scheme.
+func verifyLinkableASN1(v schemeVerifier, msg, der []byte) bool {
+	sig, err := ParseLinkableRingSignature(der)
+	if err != nil || sig.Scheme != v.Scheme() {
+		return false
+	}
+	return v.Verify(msg, sig)
+}
+
+// checkLinkableSignature checks that the signature is well formed for the
+// ring and was not explicitly marked as a signature of another scheme or as
+// made over another ring. The ring members and the key image must be valid
+// SM2 points, c must be below 2^256 and all s_i must be in [0, N), so that
+// the verification arithmetic can not panic.
+func checkLinkableSignature(scheme LinkableScheme, ring *Ring, signature *LinkableRingSignature) error {
+	if err := checkRing(ring.keys); err != nil {
+		return err
+	}
+	if ring.Len() != signature.RingSize() {
+		return ErrRingSizeMismatch
+	}
+	if len(signature.RingID) != 0 && !bytes.Equal(signature.RingID, ring.ID()) {
+		return ErrRingIDMismatch
+	}
+	if signature.Scheme != 0 && signature.Scheme != scheme {
+		return fmt.Errorf("%w: got %v, want %v", ErrSchemeMismatch, signature.Scheme, scheme)
+	}
+	if !validPoint(signature.Qx, signature.Qy) {
+		return ErrInvalidKeyImage
+	}
+	return checkScalars(signature.C, signature.S)
+}
+
+// LinkableOption configures a linkable ring signer or verifier. A signer and
+// the verifiers of its signatures must be configured with the same options.
+type LinkableOption func(*linkableOptions)
+
+type linkableOptions struct {
+	basePoint     func(ring *Ring) (x, y *big.Int)
+	requireProven bool
+}
+
+func newLinkableOptions(opts []LinkableOption) linkableOptions {
+	var o linkableOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithHashToCurve derives the linkability base point Hp by hashing the ring
+// members to the curve with HashToCurve, instead of summing them. Nobody
+// knows the discrete logarithm of such an Hp, even if they chose some of the
+// ring keys, so this is recommended for new deployments. Signatures are not
+// compatible with those made with the default Hp.
+func WithHashToCurve() LinkableOption {
+	return func(o *linkableOptions) {
+		o.basePoint = (*Ring).hashToCurvePoint
+	}
+}
+
+// hp returns the linkability base point of the ring.
+func (o *linkableOptions) hp(ring *Ring) (x, y *big.Int) {
+	if o.basePoint == nil {
+		return ring.sumPoint()
+	}
+	return o.basePoint(ring)
 }
 
 type BaseLinkableVerfier struct {
-	publicKeys []*ecdsa.PublicKey
+	linkableOptions
+	ring *Ring
+	// prepared holds precomputed tables of ring, it may be nil.
+	prepared *PreparedRing
+	// scheme overrides LinkableSchemeBase for schemes which reuse the base
+	// algorithm with another Hp, such as LinkableSchemeScoped.
+	scheme LinkableScheme
+}
+
+// NewBaseLinkableVerfier creates a verifier over a copy of pubs.
+func NewBaseLinkableVerfier(pubs []*ecdsa.PublicKey, opts ...LinkableOption) *BaseLinkableVerfier {
+	return NewBaseLinkableVerfierWithRing(newRing(pubs), opts...)
+}
+
+// NewBaseLinkableVerfierWithRing creates a verifier over ring.
+func NewBaseLinkableVerfierWithRing(ring *Ring, opts ...LinkableOption) *BaseLinkableVerfier {
+	if ring == nil {
+		ring = &Ring{}
+	}
+	return &BaseLinkableVerfier{ring: ring, linkableOptions: newLinkableOptions(opts)}
 }
 
-func NewBaseLinkableVerfier(pubs []*ecdsa.PublicKey) *BaseLinkableVerfier {
-	return &BaseLinkableVerfier{publicKeys: pubs}
+// NewBaseLinkableVerfierWithPreparedRing creates a verifier over the ring of
+// pr which uses its precomputed tables.
+func NewBaseLinkableVerfierWithPreparedRing(pr *PreparedRing, opts ...LinkableOption) *BaseLinkableVerfier {
+	if pr == nil {
+		return NewBaseLinkableVerfierWithRing(nil, opts...)
+	}
+	v := NewBaseLinkableVerfierWithRing(pr.ring, opts...)
+	v.prepared = pr
+	return v
 }
 
 type BaseLinkableSigner struct {
@@ -30,182 +164,346 @@ type BaseLinkableSigner struct {
 	privateKey *sm2.PrivateKey
 }
 
-func NewBaseLinkableSigner(privateKey *sm2.PrivateKey, pubs []*ecdsa.PublicKey) *BaseLinkableSigner {
-	return &BaseLinkableSigner{privateKey: privateKey, BaseLinkableVerfier: BaseLinkableVerfier{publicKeys: pubs}}
+// NewBaseLinkableSigner creates a signer over a copy of pubs, so later
+// changes to pubs do not affect it.
+func NewBaseLinkableSigner(privateKey *sm2.PrivateKey, pubs []*ecdsa.PublicKey, opts ...LinkableOption) *BaseLinkableSigner {
+	return NewBaseLinkableSignerWithRing(privateKey, newRing(pubs), opts...)
 }
 
-// 这个Hp 也没有明确算法描述，这里简单使用曲线点加法
-func publicKeysToPoint(pubs []*ecdsa.PublicKey) (x *big.Int, y *big.Int) {
-	x = pubs[0].X
-	y = pubs[0].Y
-	for i := 1; i < len(pubs); i++ {
-		x, y = pubs[0].Curve.Add(x, y, pubs[i].X, pubs[i].Y)
+// NewBaseLinkableSignerWithRing creates a signer over ring.
+func NewBaseLinkableSignerWithRing(privateKey *sm2.PrivateKey, ring *Ring, opts ...LinkableOption) *BaseLinkableSigner {
+	return &BaseLinkableSigner{privateKey: privateKey, BaseLinkableVerfier: *NewBaseLinkableVerfierWithRing(ring, opts...)}
+}
+
+func (v *BaseLinkableVerfier) Scheme() LinkableScheme {
+	if v.scheme != 0 {
+		return v.scheme
 	}
-	return
+	return LinkableSchemeBase
+}
+
+// VerifyASN1 verifies an ASN.1 encoded linkable ring signature, rejecting
+// signatures of other schemes.
+func (v *BaseLinkableVerfier) VerifyASN1(msg, signature []byte) bool {
+	return verifyLinkableASN1(v, msg, signature)
+}
+
+func (v *BaseLinkableVerfier) preparation() (*Ring, *PreparedRing) {
+	return v.ring, v.prepared
+}
+
+func (v *BaseLinkableVerfier) withPrepared(pr *PreparedRing) preparableVerifier {
+	cp := *v
+	cp.prepared = pr
+	return &cp
 }
 
-func hash1(pubs []*ecdsa.PublicKey, QpaiX, QpaiY *big.Int, msg []byte, vx, vy, wx, wy *big.Int) *big.Int {
-	var buffer [32]byte
-	h := sm3.New()
+// 这个Hp 也没有明确算法描述，这里简单使用曲线点加法
+//
+// The sum is linear in the ring members, so a member who chooses their key
+// after the others can know the discrete logarithm of Hp. It is kept as the
+// default for compatibility, see WithHashToCurve.
+func publicKeysToPoint(pubs []*ecdsa.PublicKey) (x *big.Int, y *big.Int) {
+	var sum point
 	for _, pub := range pubs {
-		pub.X.FillBytes(buffer[:])
-		h.Write(buffer[:])
-		pub.Y.FillBytes(buffer[:])
-		h.Write(buffer[:])
+		sum.addBig(pub.X, pub.Y)
 	}
+	return sum.affine()
+}
+
+// variantBasePoint returns the base point R = Hp + G of the linkable
+// schemes variant 1 and 2, or ErrInvalidBasePoint if it is the point at
+// infinity.
+func variantBasePoint(hp *affinePoint) (*affinePoint, error) {
+	var r point
+	return basePoint(r.setAffine(hp).addAffine(&r, p256G).affine())
+}
 
-	QpaiX.FillBytes(buffer[:])
-	h.Write(buffer[:])
-	QpaiY.FillBytes(buffer[:])
-	h.Write(buffer[:])
+// newLinkableChallengeHasher returns the challengeHasher of a linkable ring
+// signature, whose challenges hash the ring members, the key image, msg and
+// the points of the step if the scheme has any. prefix is the state after
+// absorbing the members, see Ring.hashPrefix.
+func newLinkableChallengeHasher(prefix []byte, QpaiX, QpaiY *big.Int, msg []byte) challengeHasher {
+	var q [64]byte
+	QpaiX.FillBytes(q[:32])
+	QpaiY.FillBytes(q[32:])
+	return newChallengeHasher(prefix, q[:], msg)
+}
 
-	h.Write(msg)
+func (signer *BaseLinkableSigner) Sign(rand io.Reader, participantRandInt ParticipantRandInt, msg []byte) (*LinkableRingSignature, error) {
+	return signer.SignWithStrategy(rand, participantRandInt, msg)
+}
+
+// SignWithStrategy is like Sign, but draws the scalars of the other members
+// with strategy, which gets the full signing context.
+func (signer *BaseLinkableSigner) SignWithStrategy(rand io.Reader, strategy ParticipantStrategy, msg []byte) (*LinkableRingSignature, error) {
+	return signer.SignContext(context.Background(), rand, strategy, msg)
+}
 
-	if vx != nil && vy != nil {
-		vx.FillBytes(buffer[:])
-		h.Write(buffer[:])
-		vy.FillBytes(buffer[:])
-		h.Write(buffer[:])
+// SignContext is like SignWithStrategy, but stops and returns ctx.Err() if
+// ctx is done before the signature is complete. For large rings, the work
+// which does not depend on the challenges is spread across goroutines; the
+// signature is the same as with SignWithStrategy for the same random stream.
+func (signer *BaseLinkableSigner) SignContext(ctx context.Context, rand io.Reader, strategy ParticipantStrategy, msg []byte) (*LinkableRingSignature, error) {
+	pre, err := signer.presign(ctx, rand, strategy, msg)
+	if err != nil {
+		return nil, err
 	}
+	return signer.signPresigned(ctx, pre, msg)
+}
 
-	if wx != nil && wy != nil {
-		wx.FillBytes(buffer[:])
-		h.Write(buffer[:])
-		wy.FillBytes(buffer[:])
-		h.Write(buffer[:])
+// Presign computes the part of a signature which does not depend on the
+// message, see Presignature and PresignRing.
+func (signer *BaseLinkableSigner) Presign(ctx context.Context, rand io.Reader, strategy ParticipantStrategy) (*Presignature, error) {
+	if err := checkPresignRandomness(rand, strategy); err != nil {
+		return nil, err
 	}
+	return signer.presign(ctx, rand, strategy, nil)
+}
 
-	return hashToInt(h.Sum(nil), pubs[0].Curve)
+// SignPresigned signs msg with a presignature made by the Presign method of
+// the same signer. It returns ErrPresignatureUsed if pre was used before.
+func (signer *BaseLinkableSigner) SignPresigned(ctx context.Context, pre *Presignature, msg []byte) (*LinkableRingSignature, error) {
+	if err := pre.use(signer); err != nil {
+		return nil, err
+	}
+	return signer.signPresigned(ctx, pre, msg)
 }
 
-func (signer *BaseLinkableSigner) Sign(rand io.Reader, participantRandInt ParticipantRandInt, msg []byte) ([]*big.Int, error) {
+// presign is Presign, where msg is the message if it is known, which the
+// random source and the strategy may depend on.
+func (signer *BaseLinkableSigner) presign(ctx context.Context, rand io.Reader, strategy ParticipantStrategy, msg []byte) (_ *Presignature, err error) {
+	if signer.ring == nil {
+		// the zero value has no ring
+		return nil, ErrRingTooSmall
+	}
 	priv := signer.privateKey
-	pubs := signer.publicKeys
-
-	n := len(pubs)
-	pai, err := getPai(priv, pubs)
+	if err := signer.checkProven(signer.ring); err != nil {
+		return nil, err
+	}
+	pai, err := getPai(priv, signer.ring.keys)
 	if err != nil {
 		return nil, err
 	}
 
 	// step 1, Qpai
-	rx, ry := publicKeysToPoint(pubs)
-	QpaiX, QpaiY := priv.ScalarMult(rx, ry, priv.D.Bytes())
-
-	// step 2,
-	kPai, err := randFieldElement(priv, rand)
+	rx, ry := signer.hp(signer.ring)
+	hp, err := basePoint(rx, ry)
 	if err != nil {
 		return nil, err
 	}
-	kPaiGx, kPaiGy := priv.ScalarBaseMult(kPai.Bytes())
-	krx, kry := priv.ScalarMult(rx, ry, kPai.Bytes())
-	c := hash1(pubs, QpaiX, QpaiY, msg, kPaiGx, kPaiGy, krx, kry)
+	rand, err = signingReader(rand, priv, signer.ring, signer.Scheme(), hp, msg)
+	if err != nil {
+		return nil, err
+	}
+	others := newParticipants(strategy, signer.ring, signer.Scheme(), msg)
+	pre := &Presignature{owner: signer, priv: priv, ring: signer.ring, pai: pai}
+	defer pre.discardOnError(&err)
+	if pre.qx, pre.qy, err = secretMult(hp, priv.D); err != nil {
+		return nil, err
+	}
 
-	results := make([]*big.Int, n+3)
-	results[0] = QpaiX
-	results[1] = QpaiY
-	// Step 3
-	// [pai+1, ... n)
-	for i := pai + 1; i < n; i++ {
-		s, err := participantRandInt(rand, pubs[i], msg)
-		if err != nil {
-			return nil, err
-		}
-		results[i+3] = s
-		sx, sy := priv.ScalarBaseMult(s.Bytes())
-		c.Add(s, c)
-		c.Mod(c, priv.Params().N)
-		vx, vy := priv.ScalarMult(pubs[i].X, pubs[i].Y, c.Bytes())
-		vx, vy = priv.Add(sx, sy, vx, vy)
-
-		sx, sy = priv.ScalarMult(rx, ry, s.Bytes())
-		wx, wy := priv.ScalarMult(QpaiX, QpaiY, c.Bytes())
-		wx, wy = priv.Add(sx, sy, wx, wy)
-
-		c = hash1(pubs, QpaiX, QpaiY, msg, vx, vy, wx, wy)
-	}
-	results[2] = new(big.Int).Set(c)
-	// [0...pai)
-	for i := 0; i < pai; i++ {
-		s, err := participantRandInt(rand, pubs[i], msg)
-		if err != nil {
-			return nil, err
-		}
-		results[i+3] = s
-		sx, sy := priv.ScalarBaseMult(s.Bytes())
-		c.Add(s, c)
-		c.Mod(c, priv.Params().N)
-		vx, vy := priv.ScalarMult(pubs[i].X, pubs[i].Y, c.Bytes())
-		vx, vy = priv.Add(sx, sy, vx, vy)
+	// step 2,
+	if pre.k, err = randFieldElement(priv, rand); err != nil {
+		return nil, err
+	}
+	if pre.kx, pre.ky, err = secretMult(nil, pre.k); err != nil {
+		return nil, err
+	}
+	if pre.krx, pre.kry, err = secretMult(hp, pre.k); err != nil {
+		return nil, err
+	}
+	pre.steps = newLinkableSteps(nil, signer.ring, rx, ry, pre.qx, pre.qy)
+	// the scalars of the other members and their multiples of G and Hp
+	if pre.others, err = drawParticipantSteps(ctx, rand, others, pai, baseTable(), pre.steps.r); err != nil {
+		return nil, err
+	}
+	return pre, nil
+}
 
-		sx, sy = priv.ScalarMult(rx, ry, s.Bytes())
-		wx, wy := priv.ScalarMult(QpaiX, QpaiY, c.Bytes())
-		wx, wy = priv.Add(sx, sy, wx, wy)
+// signPresigned is the online phase of SignContext and SignPresigned.
+func (signer *BaseLinkableSigner) signPresigned(ctx context.Context, pre *Presignature, msg []byte) (*LinkableRingSignature, error) {
+	defer zeroBig(pre.k)
+	ch := newLinkableChallengeHasher(signer.ring.hashPrefix(), pre.qx, pre.qy, msg)
+	c := ch.hash(pre.kx, pre.ky, pre.krx, pre.kry)
 
-		c = hash1(pubs, QpaiX, QpaiY, msg, vx, vy, wx, wy)
+	// Step 3
+	c0, c, err := pre.chain(ctx, c, func(i int, c *big.Int) *big.Int {
+		return ch.hash(pre.steps.baseFrom(i, pre.others.product(i, 0), pre.others.product(i, 1), c))
+	})
+	if err != nil {
+		return nil, err
 	}
+	sig := &LinkableRingSignature{Scheme: signer.Scheme(), C: c0, Qx: pre.qx, Qy: pre.qy, S: pre.others.s, RingID: signer.ring.ID()}
 	// Step 3: this step is same with SM2 signature scheme
-	c.Mul(c, priv.D)
-	kPai.Sub(kPai, c)
-	dp1 := new(big.Int).Add(priv.D, one)
+	sig.S[pre.pai], err = signerResponse(pre.k, c, pre.priv.D)
+	if err != nil {
+		return nil, err
+	}
+
+	return sig, nil
+}
 
-	var dp1Inv *big.Int
+// linkableSteps computes the points hashed at the steps of a linkable ring
+// signature, with multipliers of the points which are the same at every
+// step. The scalars of the steps are public, so the arithmetic does not
+// need to be constant time.
+type linkableSteps struct {
+	ring     *Ring
+	prepared *PreparedRing
+	// r is Hp in the base scheme and Hp + G in the variants, q is the key
+	// image
+	r, q multiplier
+}
 
-	if in, ok := priv.Curve.(invertible); ok {
-		dp1Inv = in.Inverse(dp1)
-	} else {
-		dp1Inv = fermatInverse(dp1, priv.Params().N) // N != 0
+// newLinkableSteps returns the steps of a signature over ring with base
+// point (rx, ry) and key image (qx, qy), using the tables of prepared if it
+// is not nil.
+func newLinkableSteps(prepared *PreparedRing, ring *Ring, rx, ry, qx, qy *big.Int) *linkableSteps {
+	n := ring.Len()
+	return &linkableSteps{
+		ring:     ring,
+		prepared: prepared,
+		r:        prepared.point(rx, ry, n),
+		q:        fixedMultiplier(qx, qy, n),
 	}
+}
 
-	kPai.Mul(kPai, dp1Inv)
-	kPai.Mod(kPai, priv.Params().N) // N != 0
+// base returns the points v = sG + cP_i and w = sHp + cQ of step i of the
+// base scheme.
+func (st *linkableSteps) base(i int, s, c *big.Int) (vx, vy, wx, wy *big.Int) {
+	var sg, sr point
+	st.r.mulAdd(&sr, s)
+	if st.prepared == nil {
+		vx, vy = ringStep(nil, st.ring, i, s, c)
+		st.q.mulAdd(&sr, c)
+		wx, wy = sr.affine()
+		return
+	}
+	baseTable().mulAdd(&sg, s)
+	return st.baseFrom(i, &sg, &sr, c)
+}
 
-	results[pai+3] = kPai
+// baseFrom is like base, where sg = sG and sr = sHp were computed
+// beforehand, see participantSteps. The results are accumulated into them.
+func (st *linkableSteps) baseFrom(i int, sg, sr *point, c *big.Int) (vx, vy, wx, wy *big.Int) {
+	vx, vy = ringStepFrom(sg, st.prepared, st.ring, i, c)
+	st.q.mulAdd(sr, c)
+	wx, wy = sr.affine()
+	return
+}
 
-	return results, nil
+// variant returns the point v = c(P_i + Q) + sR of step i of the variants,
+// where R = Hp + G.
+func (st *linkableSteps) variant(i int, s, c *big.Int) (vx, vy *big.Int) {
+	var sr point
+	st.r.mulAdd(&sr, s)
+	return st.variantFrom(i, &sr, c)
 }
 
-func (v *BaseLinkableVerfier) Verify(msg []byte, signature []*big.Int) bool {
-	pubs := v.publicKeys
-	if len(pubs)+3 != len(signature) {
-		return false
+// variantFrom is like variant, where sr = sR was computed beforehand, see
+// participantSteps. The result is accumulated into sr.
+func (st *linkableSteps) variantFrom(i int, sr *point, c *big.Int) (vx, vy *big.Int) {
+	mulAddSum(sr, st.prepared.member(st.ring, i), st.q, c)
+	return sr.affine()
+}
+
+// Verify verifies the linkable ring signature over msg. As with the package
+// level Verify, malformed signatures and rings are rejected without panicking.
+func (v *BaseLinkableVerfier) Verify(msg []byte, signature *LinkableRingSignature) bool {
+	return v.VerifyWithError(msg, signature) == nil
+}
+
+// VerifyWithError is like Verify, but reports why verification failed. The
+// returned error wraps one of the verification errors of this package, such
+// as ErrInvalidKeyImage or ErrRingEquation.
+func (v *BaseLinkableVerfier) VerifyWithError(msg []byte, signature *LinkableRingSignature) error {
+	return v.VerifyContext(context.Background(), msg, signature)
+}
+
+// VerifyContext is like VerifyWithError, but stops and returns ctx.Err() if
+// ctx is done before the verification is complete. It is checked between
+// the steps of the ring.
+func (v *BaseLinkableVerfier) VerifyContext(ctx context.Context, msg []byte, signature *LinkableRingSignature) error {
+	if v.ring == nil {
+		// the zero value has no ring
+		return ErrRingTooSmall
+	}
+	pubs := v.ring.keys
+	if err := v.checkProven(v.ring); err != nil {
+		return err
+	}
+	if err := checkLinkableSignature(v.Scheme(), v.ring, signature); err != nil {
+		return err
 	}
 
-	rx, ry := publicKeysToPoint(pubs)
-	QpaiX := signature[0]
-	QpaiY := signature[1]
+	rx, ry := v.hp(v.ring)
+	if _, err := basePoint(rx, ry); err != nil {
+		return err
+	}
+	QpaiX := signature.Qx
+	QpaiY := signature.Qy
+	ch := newLinkableChallengeHasher(v.ring.hashPrefix(), QpaiX, QpaiY, msg)
 
-	c := new(big.Int).Set(signature[2])
+	N := sm2.P256().Params().N
+	steps := newLinkableSteps(v.prepared, v.ring, rx, ry, QpaiX, QpaiY)
+	c := new(big.Int).Set(signature.C)
 	for i := 0; i < len(pubs); i++ {
-		pub := pubs[i]
-		s := signature[i+3]
-
-		sx, sy := pub.ScalarBaseMult(s.Bytes())
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s := signature.S[i]
 		c.Add(s, c)
-		c.Mod(c, pub.Params().N)
-		vx, vy := pub.ScalarMult(pub.X, pub.Y, c.Bytes())
-		vx, vy = pub.Add(sx, sy, vx, vy)
+		c.Mod(c, N)
+		c = ch.hash(steps.base(i, s, c))
+	}
 
-		sx, sy = pub.ScalarMult(rx, ry, s.Bytes())
-		wx, wy := pub.ScalarMult(QpaiX, QpaiY, c.Bytes())
-		wx, wy = pub.Add(sx, sy, wx, wy)
+	if c.Cmp(signature.C) != 0 {
+		return ErrRingEquation
+	}
+	return nil
+}
 
-		c = hash1(pubs, QpaiX, QpaiY, msg, vx, vy, wx, wy)
+// Linkable reports whether two linkable ring signatures share the same key
+// image, i.e. were produced by the same signer over the same ring.
+func Linkable(signature1, signature2 *LinkableRingSignature) bool {
+	if signature1 == nil || signature2 == nil ||
+		signature1.Qx == nil || signature1.Qy == nil ||
+		signature2.Qx == nil || signature2.Qy == nil {
+		return false
 	}
+	return signature1.Qx.Cmp(signature2.Qx) == 0 && signature1.Qy.Cmp(signature2.Qy) == 0
+}
 
-	return c.Cmp(signature[2]) == 0
+type LinkableVerfierVariant1 struct {
+	linkableOptions
+	ring *Ring
+	// prepared holds precomputed tables of ring, it may be nil.
+	prepared *PreparedRing
 }
 
-func Linkable(signature1, signature2 []*big.Int) bool {
-	return signature1[0].Cmp(signature2[0]) == 0 && signature1[1].Cmp(signature2[1]) == 0
+// NewLinkableVerfierVariant1 creates a verifier over a copy of pubs.
+func NewLinkableVerfierVariant1(pubs []*ecdsa.PublicKey, opts ...LinkableOption) *LinkableVerfierVariant1 {
+	return NewLinkableVerfierVariant1WithRing(newRing(pubs), opts...)
 }
 
-type LinkableVerfierVariant1 struct {
-	publicKeys []*ecdsa.PublicKey
+// NewLinkableVerfierVariant1WithRing creates a verifier over ring.
+func NewLinkableVerfierVariant1WithRing(ring *Ring, opts ...LinkableOption) *LinkableVerfierVariant1 {
+	if ring == nil {
+		ring = &Ring{}
+	}
+	return &LinkableVerfierVariant1{ring: ring, linkableOptions: newLinkableOptions(opts)}
 }
 
-func NewLinkableVerfierVariant1(pubs []*ecdsa.PublicKey) *LinkableVerfierVariant1 {
-	return &LinkableVerfierVariant1{publicKeys: pubs}
+// NewLinkableVerfierVariant1WithPreparedRing creates a verifier over the ring of
+// pr which uses its precomputed tables.
+func NewLinkableVerfierVariant1WithPreparedRing(pr *PreparedRing, opts ...LinkableOption) *LinkableVerfierVariant1 {
+	if pr == nil {
+		return NewLinkableVerfierVariant1WithRing(nil, opts...)
+	}
+	v := NewLinkableVerfierVariant1WithRing(pr.ring, opts...)
+	v.prepared = pr
+	return v
 }
 
 type LinkableSignerVariant1 struct {
@@ -213,267 +511,476 @@ type LinkableSignerVariant1 struct {
 	privateKey *sm2.PrivateKey
 }
 
-func NewLinkableSignerVariant1(privateKey *sm2.PrivateKey, pubs []*ecdsa.PublicKey) *LinkableSignerVariant1 {
-	return &LinkableSignerVariant1{privateKey: privateKey, LinkableVerfierVariant1: LinkableVerfierVariant1{publicKeys: pubs}}
+// NewLinkableSignerVariant1 creates a signer over a copy of pubs, so later
+// changes to pubs do not affect it.
+func NewLinkableSignerVariant1(privateKey *sm2.PrivateKey, pubs []*ecdsa.PublicKey, opts ...LinkableOption) *LinkableSignerVariant1 {
+	return NewLinkableSignerVariant1WithRing(privateKey, newRing(pubs), opts...)
 }
 
-func (signer *LinkableSignerVariant1) Sign(rand io.Reader, participantRandInt ParticipantRandInt, msg []byte) ([]*big.Int, error) {
-	priv := signer.privateKey
-	pubs := signer.publicKeys
+// NewLinkableSignerVariant1WithRing creates a signer over ring.
+func NewLinkableSignerVariant1WithRing(privateKey *sm2.PrivateKey, ring *Ring, opts ...LinkableOption) *LinkableSignerVariant1 {
+	return &LinkableSignerVariant1{privateKey: privateKey, LinkableVerfierVariant1: *NewLinkableVerfierVariant1WithRing(ring, opts...)}
+}
+
+func (v *LinkableVerfierVariant1) Scheme() LinkableScheme {
+	return LinkableSchemeVariant1
+}
+
+// VerifyASN1 verifies an ASN.1 encoded linkable ring signature, rejecting
+// signatures of other schemes.
+func (v *LinkableVerfierVariant1) VerifyASN1(msg, signature []byte) bool {
+	return verifyLinkableASN1(v, msg, signature)
+}
+
+func (v *LinkableVerfierVariant1) preparation() (*Ring, *PreparedRing) {
+	return v.ring, v.prepared
+}
 
-	n := len(pubs)
-	pai, err := getPai(priv, pubs)
+func (v *LinkableVerfierVariant1) withPrepared(pr *PreparedRing) preparableVerifier {
+	cp := *v
+	cp.prepared = pr
+	return &cp
+}
+
+func (signer *LinkableSignerVariant1) Sign(rand io.Reader, participantRandInt ParticipantRandInt, msg []byte) (*LinkableRingSignature, error) {
+	return signer.SignWithStrategy(rand, participantRandInt, msg)
+}
+
+// SignWithStrategy is like Sign, but draws the scalars of the other members
+// with strategy, which gets the full signing context.
+func (signer *LinkableSignerVariant1) SignWithStrategy(rand io.Reader, strategy ParticipantStrategy, msg []byte) (*LinkableRingSignature, error) {
+	return signer.SignContext(context.Background(), rand, strategy, msg)
+}
+
+// SignContext is like SignWithStrategy, but stops and returns ctx.Err() if
+// ctx is done before the signature is complete. For large rings, the work
+// which does not depend on the challenges is spread across goroutines; the
+// signature is the same as with SignWithStrategy for the same random stream.
+func (signer *LinkableSignerVariant1) SignContext(ctx context.Context, rand io.Reader, strategy ParticipantStrategy, msg []byte) (*LinkableRingSignature, error) {
+	pre, err := signer.presign(ctx, rand, strategy, msg)
 	if err != nil {
 		return nil, err
 	}
+	return signer.signPresigned(ctx, pre, msg)
+}
 
-	// step 1, Qpai
-	rx, ry := publicKeysToPoint(pubs)
-	QpaiX, QpaiY := priv.ScalarMult(rx, ry, priv.D.Bytes())
+// Presign computes the part of a signature which does not depend on the
+// message, see Presignature and PresignRing.
+func (signer *LinkableSignerVariant1) Presign(ctx context.Context, rand io.Reader, strategy ParticipantStrategy) (*Presignature, error) {
+	if err := checkPresignRandomness(rand, strategy); err != nil {
+		return nil, err
+	}
+	return signer.presign(ctx, rand, strategy, nil)
+}
 
-	rx, ry = priv.Add(rx, ry, priv.Params().Gx, priv.Params().Gy)
+// SignPresigned signs msg with a presignature made by the Presign method of
+// the same signer. It returns ErrPresignatureUsed if pre was used before.
+func (signer *LinkableSignerVariant1) SignPresigned(ctx context.Context, pre *Presignature, msg []byte) (*LinkableRingSignature, error) {
+	if err := pre.use(signer); err != nil {
+		return nil, err
+	}
+	return signer.signPresigned(ctx, pre, msg)
+}
 
-	// step 2,
-	kPai, err := randFieldElement(priv, rand)
+// presign is Presign, where msg is the message if it is known, which the
+// random source and the strategy may depend on.
+func (signer *LinkableSignerVariant1) presign(ctx context.Context, rand io.Reader, strategy ParticipantStrategy, msg []byte) (_ *Presignature, err error) {
+	if signer.ring == nil {
+		// the zero value has no ring
+		return nil, ErrRingTooSmall
+	}
+	priv := signer.privateKey
+	if err := signer.checkProven(signer.ring); err != nil {
+		return nil, err
+	}
+	pai, err := getPai(priv, signer.ring.keys)
 	if err != nil {
 		return nil, err
 	}
 
-	krx, kry := priv.ScalarMult(rx, ry, kPai.Bytes())
-	c := hash1(pubs, QpaiX, QpaiY, msg, krx, kry, nil, nil)
+	// step 1, Qpai
+	rx, ry := signer.hp(signer.ring)
+	hp, err := basePoint(rx, ry)
+	if err != nil {
+		return nil, err
+	}
+	rand, err = signingReader(rand, priv, signer.ring, signer.Scheme(), hp, msg)
+	if err != nil {
+		return nil, err
+	}
+	others := newParticipants(strategy, signer.ring, signer.Scheme(), msg)
+	pre := &Presignature{owner: signer, priv: priv, ring: signer.ring, pai: pai}
+	defer pre.discardOnError(&err)
+	if pre.qx, pre.qy, err = secretMult(hp, priv.D); err != nil {
+		return nil, err
+	}
 
-	results := make([]*big.Int, n+3)
-	results[0] = QpaiX
-	results[1] = QpaiY
-	// Step 3
-	// [pai+1, ... n)
-	for i := pai + 1; i < n; i++ {
-		s, err := participantRandInt(rand, pubs[i], msg)
-		if err != nil {
-			return nil, err
-		}
-		results[i+3] = s
-		c.Add(s, c)
-		c.Mod(c, priv.Params().N)
+	r, err := variantBasePoint(hp)
+	if err != nil {
+		return nil, err
+	}
+	rx, ry = r.Big()
 
-		vx, vy := priv.Add(pubs[i].X, pubs[i].Y, QpaiX, QpaiY)
-		vx, vy = priv.ScalarMult(vx, vy, c.Bytes())
+	// step 2,
+	if pre.k, err = randFieldElement(priv, rand); err != nil {
+		return nil, err
+	}
+	if pre.krx, pre.kry, err = secretMult(r, pre.k); err != nil {
+		return nil, err
+	}
+	pre.steps = newLinkableSteps(nil, signer.ring, rx, ry, pre.qx, pre.qy)
+	// the scalars of the other members and their multiples of R
+	if pre.others, err = drawParticipantSteps(ctx, rand, others, pai, pre.steps.r); err != nil {
+		return nil, err
+	}
+	return pre, nil
+}
 
-		sx, sy := priv.ScalarMult(rx, ry, s.Bytes())
-		vx, vy = priv.Add(sx, sy, vx, vy)
+// signPresigned is the online phase of SignContext and SignPresigned.
+func (signer *LinkableSignerVariant1) signPresigned(ctx context.Context, pre *Presignature, msg []byte) (*LinkableRingSignature, error) {
+	defer zeroBig(pre.k)
+	ch := newLinkableChallengeHasher(signer.ring.hashPrefix(), pre.qx, pre.qy, msg)
+	c := ch.hash(pre.krx, pre.kry)
 
-		c = hash1(pubs, QpaiX, QpaiY, msg, vx, vy, nil, nil)
+	// Step 3
+	c0, c, err := pre.chain(ctx, c, func(i int, c *big.Int) *big.Int {
+		return ch.hash(pre.steps.variantFrom(i, pre.others.product(i, 0), c))
+	})
+	if err != nil {
+		return nil, err
 	}
-	results[2] = new(big.Int).Set(c)
-	// [0...pai)
-	for i := 0; i < pai; i++ {
-		s, err := participantRandInt(rand, pubs[i], msg)
-		if err != nil {
-			return nil, err
-		}
-		results[i+3] = s
-		c.Add(s, c)
-		c.Mod(c, priv.Params().N)
+	sig := &LinkableRingSignature{Scheme: LinkableSchemeVariant1, C: c0, Qx: pre.qx, Qy: pre.qy, S: pre.others.s, RingID: signer.ring.ID()}
+	// Step 3: this step is same with SM2 signature scheme
+	sig.S[pre.pai], err = signerResponse(pre.k, c, pre.priv.D)
+	if err != nil {
+		return nil, err
+	}
+
+	return sig, nil
+}
 
-		vx, vy := priv.Add(pubs[i].X, pubs[i].Y, QpaiX, QpaiY)
-		vx, vy = priv.ScalarMult(vx, vy, c.Bytes())
+// Verify verifies the linkable ring signature over msg. As with the package
+// level Verify, malformed signatures and rings are rejected without panicking.
+func (v *LinkableVerfierVariant1) Verify(msg []byte, signature *LinkableRingSignature) bool {
+	return v.VerifyWithError(msg, signature) == nil
+}
 
-		sx, sy := priv.ScalarMult(rx, ry, s.Bytes())
-		vx, vy = priv.Add(sx, sy, vx, vy)
+// VerifyWithError is like Verify, but reports why verification failed. The
+// returned error wraps one of the verification errors of this package, such
+// as ErrInvalidKeyImage or ErrRingEquation.
+func (v *LinkableVerfierVariant1) VerifyWithError(msg []byte, signature *LinkableRingSignature) error {
+	return v.VerifyContext(context.Background(), msg, signature)
+}
 
-		c = hash1(pubs, QpaiX, QpaiY, msg, vx, vy, nil, nil)
+// VerifyContext is like VerifyWithError, but stops and returns ctx.Err() if
+// ctx is done before the verification is complete. It is checked between
+// the steps of the ring.
+func (v *LinkableVerfierVariant1) VerifyContext(ctx context.Context, msg []byte, signature *LinkableRingSignature) error {
+	if v.ring == nil {
+		// the zero value has no ring
+		return ErrRingTooSmall
+	}
+	pubs := v.ring.keys
+	if err := v.checkProven(v.ring); err != nil {
+		return err
+	}
+	if err := checkLinkableSignature(v.Scheme(), v.ring, signature); err != nil {
+		return err
 	}
-	// Step 3: this step is same with SM2 signature scheme
-	c.Mul(c, priv.D)
-	kPai.Sub(kPai, c)
-	dp1 := new(big.Int).Add(priv.D, one)
 
-	var dp1Inv *big.Int
+	hp, err := basePoint(v.hp(v.ring))
+	if err != nil {
+		return err
+	}
+	r, err := variantBasePoint(hp)
+	if err != nil {
+		return err
+	}
+	rx, ry := r.Big()
+	QpaiX := signature.Qx
+	QpaiY := signature.Qy
+	ch := newLinkableChallengeHasher(v.ring.hashPrefix(), QpaiX, QpaiY, msg)
+
+	N := sm2.P256().Params().N
+	steps := newLinkableSteps(v.prepared, v.ring, rx, ry, QpaiX, QpaiY)
+	c := new(big.Int).Set(signature.C)
+	for i := 0; i < len(pubs); i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s := signature.S[i]
+		c.Add(s, c)
+		c.Mod(c, N)
+		c = ch.hash(steps.variant(i, s, c))
+	}
 
-	if in, ok := priv.Curve.(invertible); ok {
-		dp1Inv = in.Inverse(dp1)
-	} else {
-		dp1Inv = fermatInverse(dp1, priv.Params().N) // N != 0
+	if c.Cmp(signature.C) != 0 {
+		return ErrRingEquation
 	}
+	return nil
+}
 
-	kPai.Mul(kPai, dp1Inv)
-	kPai.Mod(kPai, priv.Params().N) // N != 0
+type LinkableVerfierVariant2 struct {
+	linkableOptions
+	ring *Ring
+	// prepared holds precomputed tables of ring, it may be nil.
+	prepared *PreparedRing
+}
 
-	results[pai+3] = kPai
+// NewLinkableVerfierVariant2 creates a verifier over a copy of pubs.
+//
+// In the first release, NewLinkableVerfierVariant2 and
+// NewLinkableSignerVariant2 returned variant 1 verifiers and signers, so
+// the "variant 2" signatures made with them are variant 1 signatures,
+// which this verifier rejects with ErrRingEquation. Convert such legacy
+// signatures with NewLinkableRingSignature(LinkableSchemeVariant1, sig) and
+// verify them with a LinkableVerfierVariant1 instead.
+func NewLinkableVerfierVariant2(pubs []*ecdsa.PublicKey, opts ...LinkableOption) *LinkableVerfierVariant2 {
+	return NewLinkableVerfierVariant2WithRing(newRing(pubs), opts...)
+}
 
-	return results, nil
+// NewLinkableVerfierVariant2WithRing creates a verifier over ring.
+func NewLinkableVerfierVariant2WithRing(ring *Ring, opts ...LinkableOption) *LinkableVerfierVariant2 {
+	if ring == nil {
+		ring = &Ring{}
+	}
+	return &LinkableVerfierVariant2{ring: ring, linkableOptions: newLinkableOptions(opts)}
 }
 
-func (v *LinkableSignerVariant1) Verify(msg []byte, signature []*big.Int) bool {
-	pubs := v.publicKeys
-	if len(pubs)+3 != len(signature) {
-		return false
+// NewLinkableVerfierVariant2WithPreparedRing creates a verifier over the ring of
+// pr which uses its precomputed tables.
+func NewLinkableVerfierVariant2WithPreparedRing(pr *PreparedRing, opts ...LinkableOption) *LinkableVerfierVariant2 {
+	if pr == nil {
+		return NewLinkableVerfierVariant2WithRing(nil, opts...)
 	}
+	v := NewLinkableVerfierVariant2WithRing(pr.ring, opts...)
+	v.prepared = pr
+	return v
+}
 
-	rx, ry := publicKeysToPoint(pubs)
-	rx, ry = pubs[0].Add(rx, ry, pubs[0].Params().Gx, pubs[0].Params().Gy)
-	QpaiX := signature[0]
-	QpaiY := signature[1]
+type LinkableSignerVariant2 struct {
+	LinkableVerfierVariant2
+	privateKey *sm2.PrivateKey
+}
 
-	c := new(big.Int).Set(signature[2])
-	for i := 0; i < len(pubs); i++ {
-		pub := pubs[i]
-		s := signature[i+3]
+// NewLinkableSignerVariant2 creates a signer over a copy of pubs, so later
+// changes to pubs do not affect it. Unlike in the first release, it signs
+// with variant 2, see NewLinkableVerfierVariant2.
+func NewLinkableSignerVariant2(privateKey *sm2.PrivateKey, pubs []*ecdsa.PublicKey, opts ...LinkableOption) *LinkableSignerVariant2 {
+	return NewLinkableSignerVariant2WithRing(privateKey, newRing(pubs), opts...)
+}
 
-		c.Add(s, c)
-		c.Mod(c, pub.Params().N)
+// NewLinkableSignerVariant2WithRing creates a signer over ring.
+func NewLinkableSignerVariant2WithRing(privateKey *sm2.PrivateKey, ring *Ring, opts ...LinkableOption) *LinkableSignerVariant2 {
+	return &LinkableSignerVariant2{privateKey: privateKey, LinkableVerfierVariant2: *NewLinkableVerfierVariant2WithRing(ring, opts...)}
+}
 
-		vx, vy := pub.Add(pub.X, pub.Y, QpaiX, QpaiY)
-		vx, vy = pub.ScalarMult(vx, vy, c.Bytes())
+func (v *LinkableVerfierVariant2) Scheme() LinkableScheme {
+	return LinkableSchemeVariant2
+}
 
-		sx, sy := pub.ScalarMult(rx, ry, s.Bytes())
-		vx, vy = pub.Add(sx, sy, vx, vy)
+// VerifyASN1 verifies an ASN.1 encoded linkable ring signature, rejecting
+// signatures of other schemes.
+func (v *LinkableVerfierVariant2) VerifyASN1(msg, signature []byte) bool {
+	return verifyLinkableASN1(v, msg, signature)
+}
 
-		c = hash1(pubs, QpaiX, QpaiY, msg, vx, vy, nil, nil)
-	}
+func (v *LinkableVerfierVariant2) preparation() (*Ring, *PreparedRing) {
+	return v.ring, v.prepared
+}
 
-	return c.Cmp(signature[2]) == 0
+func (v *LinkableVerfierVariant2) withPrepared(pr *PreparedRing) preparableVerifier {
+	cp := *v
+	cp.prepared = pr
+	return &cp
 }
 
-type LinkableVerfierVariant2 struct {
-	publicKeys []*ecdsa.PublicKey
+func (signer *LinkableSignerVariant2) Sign(rand io.Reader, participantRandInt ParticipantRandInt, msg []byte) (*LinkableRingSignature, error) {
+	return signer.SignWithStrategy(rand, participantRandInt, msg)
 }
 
-func NewLinkableVerfierVariant2(pubs []*ecdsa.PublicKey) *LinkableVerfierVariant1 {
-	return &LinkableVerfierVariant1{publicKeys: pubs}
+// SignWithStrategy is like Sign, but draws the scalars of the other members
+// with strategy, which gets the full signing context.
+func (signer *LinkableSignerVariant2) SignWithStrategy(rand io.Reader, strategy ParticipantStrategy, msg []byte) (*LinkableRingSignature, error) {
+	return signer.SignContext(context.Background(), rand, strategy, msg)
 }
 
-type LinkableSignerVariant2 struct {
-	LinkableVerfierVariant2
-	privateKey *sm2.PrivateKey
+// SignContext is like SignWithStrategy, but stops and returns ctx.Err() if
+// ctx is done before the signature is complete. For large rings, the work
+// which does not depend on the challenges is spread across goroutines; the
+// signature is the same as with SignWithStrategy for the same random stream.
+func (signer *LinkableSignerVariant2) SignContext(ctx context.Context, rand io.Reader, strategy ParticipantStrategy, msg []byte) (*LinkableRingSignature, error) {
+	pre, err := signer.presign(ctx, rand, strategy, msg)
+	if err != nil {
+		return nil, err
+	}
+	return signer.signPresigned(ctx, pre, msg)
 }
 
-func NewLinkableSignerVariant2(privateKey *sm2.PrivateKey, pubs []*ecdsa.PublicKey) *LinkableSignerVariant1 {
-	return &LinkableSignerVariant1{privateKey: privateKey, LinkableVerfierVariant1: LinkableVerfierVariant1{publicKeys: pubs}}
+// Presign computes the part of a signature which does not depend on the
+// message, see Presignature and PresignRing.
+func (signer *LinkableSignerVariant2) Presign(ctx context.Context, rand io.Reader, strategy ParticipantStrategy) (*Presignature, error) {
+	if err := checkPresignRandomness(rand, strategy); err != nil {
+		return nil, err
+	}
+	return signer.presign(ctx, rand, strategy, nil)
 }
 
-func (signer *LinkableSignerVariant2) Sign(rand io.Reader, participantRandInt ParticipantRandInt, msg []byte) ([]*big.Int, error) {
-	priv := signer.privateKey
-	pubs := signer.publicKeys
+// SignPresigned signs msg with a presignature made by the Presign method of
+// the same signer. It returns ErrPresignatureUsed if pre was used before.
+func (signer *LinkableSignerVariant2) SignPresigned(ctx context.Context, pre *Presignature, msg []byte) (*LinkableRingSignature, error) {
+	if err := pre.use(signer); err != nil {
+		return nil, err
+	}
+	return signer.signPresigned(ctx, pre, msg)
+}
 
-	n := len(pubs)
-	pai, err := getPai(priv, pubs)
+// presign is Presign, where msg is the message if it is known, which the
+// random source and the strategy may depend on.
+func (signer *LinkableSignerVariant2) presign(ctx context.Context, rand io.Reader, strategy ParticipantStrategy, msg []byte) (_ *Presignature, err error) {
+	if signer.ring == nil {
+		// the zero value has no ring
+		return nil, ErrRingTooSmall
+	}
+	priv := signer.privateKey
+	if err := signer.checkProven(signer.ring); err != nil {
+		return nil, err
+	}
+	pai, err := getPai(priv, signer.ring.keys)
 	if err != nil {
 		return nil, err
 	}
 
 	// step 1, Qpai
-	rx, ry := publicKeysToPoint(pubs)
-	QpaiX, QpaiY := priv.ScalarMult(rx, ry, priv.D.Bytes())
+	rx, ry := signer.hp(signer.ring)
+	hp, err := basePoint(rx, ry)
+	if err != nil {
+		return nil, err
+	}
+	rand, err = signingReader(rand, priv, signer.ring, signer.Scheme(), hp, msg)
+	if err != nil {
+		return nil, err
+	}
+	others := newParticipants(strategy, signer.ring, signer.Scheme(), msg)
+	pre := &Presignature{owner: signer, priv: priv, ring: signer.ring, pai: pai}
+	defer pre.discardOnError(&err)
+	if pre.qx, pre.qy, err = secretMult(hp, priv.D); err != nil {
+		return nil, err
+	}
 
-	rx, ry = priv.Add(rx, ry, priv.Params().Gx, priv.Params().Gy)
+	r, err := variantBasePoint(hp)
+	if err != nil {
+		return nil, err
+	}
+	rx, ry = r.Big()
 
 	// step 2,
-	kPai, err := randFieldElement(priv, rand)
-	if err != nil {
+	if pre.k, err = randFieldElement(priv, rand); err != nil {
+		return nil, err
+	}
+	if pre.krx, pre.kry, err = secretMult(r, pre.k); err != nil {
 		return nil, err
 	}
+	pre.steps = newLinkableSteps(nil, signer.ring, rx, ry, pre.qx, pre.qy)
+	// the scalars of the other members and their multiples of R
+	if pre.others, err = drawParticipantSteps(ctx, rand, others, pai, pre.steps.r); err != nil {
+		return nil, err
+	}
+	return pre, nil
+}
 
-	krx, _ := priv.ScalarMult(rx, ry, kPai.Bytes())
-	c := hash1(pubs, QpaiX, QpaiY, msg, nil, nil, nil, nil)
-	c.Add(krx, c)
-	c.Mod(c, priv.Params().N)
+// signPresigned is the online phase of SignContext and SignPresigned.
+func (signer *LinkableSignerVariant2) signPresigned(ctx context.Context, pre *Presignature, msg []byte) (*LinkableRingSignature, error) {
+	defer zeroBig(pre.k)
+	N := pre.priv.Params().N
+	// the hash of variant 2 does not depend on the step
+	h := newLinkableChallengeHasher(signer.ring.hashPrefix(), pre.qx, pre.qy, msg).hash()
+	c := new(big.Int).Set(h)
+	c.Add(pre.krx, c)
+	c.Mod(c, N)
 
-	results := make([]*big.Int, n+3)
-	results[0] = QpaiX
-	results[1] = QpaiY
 	// Step 3
-	// [pai+1, ... n)
-	for i := pai + 1; i < n; i++ {
-		s, err := participantRandInt(rand, pubs[i], msg)
-		if err != nil {
-			return nil, err
-		}
-		results[i+3] = s
-		c.Add(s, c)
-		c.Mod(c, priv.Params().N)
-
-		vx, vy := priv.Add(pubs[i].X, pubs[i].Y, QpaiX, QpaiY)
-		vx, vy = priv.ScalarMult(vx, vy, c.Bytes())
-
-		sx, sy := priv.ScalarMult(rx, ry, s.Bytes())
-		vx, _ = priv.Add(sx, sy, vx, vy)
-
-		c = hash1(pubs, QpaiX, QpaiY, msg, nil, nil, nil, nil)
-		c.Add(vx, c)
-		c.Mod(c, priv.Params().N)
-	}
-	results[2] = new(big.Int).Set(c)
-	// [0...pai)
-	for i := 0; i < pai; i++ {
-		s, err := participantRandInt(rand, pubs[i], msg)
-		if err != nil {
-			return nil, err
-		}
-		results[i+3] = s
-		c.Add(s, c)
-		c.Mod(c, priv.Params().N)
-
-		vx, vy := priv.Add(pubs[i].X, pubs[i].Y, QpaiX, QpaiY)
-		vx, vy = priv.ScalarMult(vx, vy, c.Bytes())
-
-		sx, sy := priv.ScalarMult(rx, ry, s.Bytes())
-		vx, _ = priv.Add(sx, sy, vx, vy)
-
-		c = hash1(pubs, QpaiX, QpaiY, msg, nil, nil, nil, nil)
+	c0, c, err := pre.chain(ctx, c, func(i int, c *big.Int) *big.Int {
+		vx, _ := pre.steps.variantFrom(i, pre.others.product(i, 0), c)
+		c = new(big.Int).Set(h)
 		c.Add(vx, c)
-		c.Mod(c, priv.Params().N)
+		return c.Mod(c, N)
+	})
+	if err != nil {
+		return nil, err
 	}
+	sig := &LinkableRingSignature{Scheme: LinkableSchemeVariant2, C: c0, Qx: pre.qx, Qy: pre.qy, S: pre.others.s, RingID: signer.ring.ID()}
 	// Step 3: this step is same with SM2 signature scheme
-	c.Mul(c, priv.D)
-	kPai.Sub(kPai, c)
-	dp1 := new(big.Int).Add(priv.D, one)
-
-	var dp1Inv *big.Int
-
-	if in, ok := priv.Curve.(invertible); ok {
-		dp1Inv = in.Inverse(dp1)
-	} else {
-		dp1Inv = fermatInverse(dp1, priv.Params().N) // N != 0
+	sig.S[pre.pai], err = signerResponse(pre.k, c, pre.priv.D)
+	if err != nil {
+		return nil, err
 	}
 
-	kPai.Mul(kPai, dp1Inv)
-	kPai.Mod(kPai, priv.Params().N) // N != 0
+	return sig, nil
+}
 
-	results[pai+3] = kPai
+// Verify verifies the linkable ring signature over msg. As with the package
+// level Verify, malformed signatures and rings are rejected without panicking.
+func (v *LinkableVerfierVariant2) Verify(msg []byte, signature *LinkableRingSignature) bool {
+	return v.VerifyWithError(msg, signature) == nil
+}
 
-	return results, nil
+// VerifyWithError is like Verify, but reports why verification failed. The
+// returned error wraps one of the verification errors of this package, such
+// as ErrInvalidKeyImage or ErrRingEquation.
+func (v *LinkableVerfierVariant2) VerifyWithError(msg []byte, signature *LinkableRingSignature) error {
+	return v.VerifyContext(context.Background(), msg, signature)
 }
 
-func (v *LinkableSignerVariant2) Verify(msg []byte, signature []*big.Int) bool {
-	pubs := v.publicKeys
-	if len(pubs)+3 != len(signature) {
-		return false
+// VerifyContext is like VerifyWithError, but stops and returns ctx.Err() if
+// ctx is done before the verification is complete. It is checked between
+// the steps of the ring.
+func (v *LinkableVerfierVariant2) VerifyContext(ctx context.Context, msg []byte, signature *LinkableRingSignature) error {
+	if v.ring == nil {
+		// the zero value has no ring
+		return ErrRingTooSmall
+	}
+	pubs := v.ring.keys
+	if err := v.checkProven(v.ring); err != nil {
+		return err
+	}
+	if err := checkLinkableSignature(v.Scheme(), v.ring, signature); err != nil {
+		return err
 	}
 
-	rx, ry := publicKeysToPoint(pubs)
-	rx, ry = pubs[0].Add(rx, ry, pubs[0].Params().Gx, pubs[0].Params().Gy)
-	QpaiX := signature[0]
-	QpaiY := signature[1]
-
-	c := new(big.Int).Set(signature[2])
+	hp, err := basePoint(v.hp(v.ring))
+	if err != nil {
+		return err
+	}
+	r, err := variantBasePoint(hp)
+	if err != nil {
+		return err
+	}
+	rx, ry := r.Big()
+	QpaiX := signature.Qx
+	QpaiY := signature.Qy
+	// the hash of variant 2 does not depend on the step
+	h := newLinkableChallengeHasher(v.ring.hashPrefix(), QpaiX, QpaiY, msg).hash()
+
+	N := sm2.P256().Params().N
+	steps := newLinkableSteps(v.prepared, v.ring, rx, ry, QpaiX, QpaiY)
+	c := new(big.Int).Set(signature.C)
 	for i := 0; i < len(pubs); i++ {
-		pub := pubs[i]
-		s := signature[i+3]
-
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s := signature.S[i]
 		c.Add(s, c)
-		c.Mod(c, pub.Params().N)
-
-		vx, vy := pub.Add(pub.X, pub.Y, QpaiX, QpaiY)
-		vx, vy = pub.ScalarMult(vx, vy, c.Bytes())
+		c.Mod(c, N)
+		vx, _ := steps.variant(i, s, c)
 
-		sx, sy := pub.ScalarMult(rx, ry, s.Bytes())
-		vx, _ = pub.Add(sx, sy, vx, vy)
-
-		c = hash1(pubs, QpaiX, QpaiY, msg, nil, nil, nil, nil)
+		c = new(big.Int).Set(h)
 		c.Add(vx, c)
-		c.Mod(c, pub.Params().N)
+		c.Mod(c, N)
 	}
 
-	return c.Cmp(signature[2]) == 0
+	if c.Cmp(signature.C) != 0 {
+		return ErrRingEquation
+	}
+	return nil
 }
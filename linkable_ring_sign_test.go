@@ -3,6 +3,9 @@ package sm2rsign
 import (
 	"crypto/ecdsa"
 	"crypto/rand"
+	"errors"
+	"io"
+	"math/big"
 	"testing"
 
 	"github.com/emmansun/gmsm/sm2"
@@ -193,3 +196,365 @@ func TestLRSignVariant2WithTwoKeys(t *testing.T) {
 	testLRSignVariant2WithTwoKeys(t, SimpleParticipantRandInt)
 	testLRSignVariant2WithTwoKeys(t, SM2ParticipantRandInt)
 }
+
+func TestLinkableVerifyMalformedInput(t *testing.T) {
+	signer, _ := sm2.GenerateKey(rand.Reader)
+	participant, _ := sm2.GenerateKey(rand.Reader)
+	pubs := []*ecdsa.PublicKey{&signer.PublicKey, &participant.PublicKey}
+	msg := []byte("hello world")
+
+	huge := new(big.Int).Lsh(big.NewInt(1), 300)
+	mutations := []struct {
+		name   string
+		mutate func(sig *LinkableRingSignature)
+	}{
+		{"nil key image", func(sig *LinkableRingSignature) { sig.Qx = nil }},
+		{"infinity key image", func(sig *LinkableRingSignature) { sig.Qx, sig.Qy = new(big.Int), new(big.Int) }},
+		{"off-curve key image", func(sig *LinkableRingSignature) { sig.Qy = new(big.Int).Add(sig.Qy, big.NewInt(1)) }},
+		{"oversized key image", func(sig *LinkableRingSignature) { sig.Qx = huge }},
+		{"nil c", func(sig *LinkableRingSignature) { sig.C = nil }},
+		{"c of 257 bits", func(sig *LinkableRingSignature) { sig.C = new(big.Int).Lsh(big.NewInt(1), 256) }},
+		{"negative s", func(sig *LinkableRingSignature) { sig.S[1] = big.NewInt(-1) }},
+		{"oversized s", func(sig *LinkableRingSignature) { sig.S[0] = huge }},
+		{"too many s", func(sig *LinkableRingSignature) { sig.S = append(sig.S, big.NewInt(1)) }},
+	}
+	signers := []interface {
+		RingSigner
+		RingVerifier
+	}{
+		NewBaseLinkableSigner(signer, pubs),
+		NewLinkableSignerVariant1(signer, pubs),
+		NewLinkableSignerVariant2(signer, pubs),
+	}
+	for _, s := range signers {
+		sig, err := s.Sign(rand.Reader, SimpleParticipantRandInt, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Verify(msg, nil) {
+			t.Errorf("%v: verified a nil signature", sig.Scheme)
+		}
+		for _, m := range mutations {
+			malformed := *sig
+			malformed.S = append([]*big.Int{}, sig.S...)
+			m.mutate(&malformed)
+			if s.Verify(msg, &malformed) {
+				t.Errorf("%v: %s: verified a malformed signature", sig.Scheme, m.name)
+			}
+		}
+		if !s.Verify(msg, sig) {
+			t.Errorf("%v: failed to verify the signature", sig.Scheme)
+		}
+	}
+
+	offCurve := []*ecdsa.PublicKey{pubs[0], {Curve: sm2.P256(), X: big.NewInt(1), Y: big.NewInt(2)}}
+	sig, err := signers[0].Sign(rand.Reader, SimpleParticipantRandInt, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if NewBaseLinkableVerfier(offCurve).Verify(msg, sig) ||
+		NewLinkableVerfierVariant1(offCurve).Verify(msg, sig) ||
+		NewLinkableVerfierVariant2(offCurve).Verify(msg, sig) {
+		t.Errorf("verified against a ring with an off-curve public key")
+	}
+}
+
+func TestLinkableVerifyWithError(t *testing.T) {
+	signer, _ := sm2.GenerateKey(rand.Reader)
+	participant, _ := sm2.GenerateKey(rand.Reader)
+	pubs := []*ecdsa.PublicKey{&signer.PublicKey, &participant.PublicKey}
+	msg := []byte("hello world")
+
+	signers := []interface {
+		RingSigner
+		VerifyWithError(msg []byte, signature *LinkableRingSignature) error
+	}{
+		NewBaseLinkableSigner(signer, pubs),
+		NewLinkableSignerVariant1(signer, pubs),
+		NewLinkableSignerVariant2(signer, pubs),
+	}
+	for _, s := range signers {
+		sig, err := s.Sign(rand.Reader, SimpleParticipantRandInt, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.VerifyWithError(msg, sig); err != nil {
+			t.Fatalf("%v: %v", sig.Scheme, err)
+		}
+		otherScheme := *sig
+		otherScheme.Scheme = sig.Scheme%3 + 1
+		offCurve := *sig
+		offCurve.Qy = new(big.Int).Add(sig.Qy, big.NewInt(1))
+		outOfRange := *sig
+		outOfRange.S = []*big.Int{sig.S[0], big.NewInt(-1)}
+		tooShort := *sig
+		tooShort.S = sig.S[:1]
+		tests := []struct {
+			name string
+			msg  []byte
+			sig  *LinkableRingSignature
+			err  error
+		}{
+			{"scheme mismatch", msg, &otherScheme, ErrSchemeMismatch},
+			{"invalid key image", msg, &offCurve, ErrInvalidKeyImage},
+			{"scalar out of range", msg, &outOfRange, ErrScalarOutOfRange},
+			{"ring size mismatch", msg, &tooShort, ErrRingSizeMismatch},
+			{"ring equation", []byte("hello"), sig, ErrRingEquation},
+		}
+		for _, tt := range tests {
+			if err := s.VerifyWithError(tt.msg, tt.sig); !errors.Is(err, tt.err) {
+				t.Errorf("%v: %s: got %v, want %v", sig.Scheme, tt.name, err, tt.err)
+			}
+		}
+	}
+}
+
+func TestLinkableSignErrors(t *testing.T) {
+	constructors := []func(priv *sm2.PrivateKey, pubs []*ecdsa.PublicKey) RingSigner{
+		func(priv *sm2.PrivateKey, pubs []*ecdsa.PublicKey) RingSigner {
+			return NewBaseLinkableSigner(priv, pubs)
+		},
+		func(priv *sm2.PrivateKey, pubs []*ecdsa.PublicKey) RingSigner {
+			return NewLinkableSignerVariant1(priv, pubs)
+		},
+		func(priv *sm2.PrivateKey, pubs []*ecdsa.PublicKey) RingSigner {
+			return NewLinkableSignerVariant2(priv, pubs)
+		},
+	}
+	for _, newSigner := range constructors {
+		testSignErrors(t, func(priv *sm2.PrivateKey, pubs []*ecdsa.PublicKey, rand io.Reader, participantRandInt ParticipantRandInt) error {
+			_, err := newSigner(priv, pubs).Sign(rand, participantRandInt, []byte("hello world"))
+			return err
+		})
+	}
+}
+
+func TestLinkableZeroValue(t *testing.T) {
+	signer, _ := sm2.GenerateKey(rand.Reader)
+	participant, _ := sm2.GenerateKey(rand.Reader)
+	pubs := []*ecdsa.PublicKey{&signer.PublicKey, &participant.PublicKey}
+	msg := []byte("hello world")
+	sig, err := NewBaseLinkableSigner(signer, pubs).Sign(rand.Reader, SimpleParticipantRandInt, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the zero values have no ring, they must fail without panicking
+	verifiers := []interface {
+		RingVerifier
+		VerifyWithError(msg []byte, signature *LinkableRingSignature) error
+	}{
+		&BaseLinkableVerfier{},
+		&LinkableVerfierVariant1{},
+		&LinkableVerfierVariant2{},
+		&ScopedLinkableVerfier{},
+	}
+	for i, v := range verifiers {
+		if v.Verify(msg, sig) {
+			t.Errorf("verifier %d: verified without a ring", i)
+		}
+		if err := v.VerifyWithError(msg, sig); !errors.Is(err, ErrRingTooSmall) {
+			t.Errorf("verifier %d: got %v, want ErrRingTooSmall", i, err)
+		}
+	}
+	signers := []RingSigner{
+		&BaseLinkableSigner{},
+		&LinkableSignerVariant1{},
+		&LinkableSignerVariant2{},
+		&ScopedLinkableSigner{},
+	}
+	for i, s := range signers {
+		if _, err := s.Sign(rand.Reader, SimpleParticipantRandInt, msg); !errors.Is(err, ErrRingTooSmall) {
+			t.Errorf("signer %d: got %v, want ErrRingTooSmall", i, err)
+		}
+	}
+}
+
+func TestLinkableBasePointAtInfinity(t *testing.T) {
+	// the members of the ring {P, -P} sum up to the point at infinity, the
+	// legacy base point of the ring
+	priv, _ := sm2.GenerateKey(rand.Reader)
+	neg := &ecdsa.PublicKey{Curve: priv.Curve, X: priv.X, Y: new(big.Int).Sub(priv.Params().P, priv.Y)}
+	ring, err := NewRing([]*ecdsa.PublicKey{&priv.PublicKey, neg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("hello world")
+	tests := []struct {
+		signer, h2c RingSigner
+		verifier    errorVerifier
+	}{
+		{NewBaseLinkableSignerWithRing(priv, ring), NewBaseLinkableSignerWithRing(priv, ring, WithHashToCurve()), NewBaseLinkableVerfierWithRing(ring)},
+		{NewLinkableSignerVariant1WithRing(priv, ring), NewLinkableSignerVariant1WithRing(priv, ring, WithHashToCurve()), NewLinkableVerfierVariant1WithRing(ring)},
+		{NewLinkableSignerVariant2WithRing(priv, ring), NewLinkableSignerVariant2WithRing(priv, ring, WithHashToCurve()), NewLinkableVerfierVariant2WithRing(ring)},
+	}
+	for _, tt := range tests {
+		if _, err := tt.signer.Sign(rand.Reader, SimpleParticipantRandInt, msg); !errors.Is(err, ErrInvalidBasePoint) {
+			t.Errorf("got %v, want %v", err, ErrInvalidBasePoint)
+		}
+		// a well-formed signature with another base point
+		sig, err := tt.h2c.Sign(rand.Reader, SimpleParticipantRandInt, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tt.verifier.VerifyWithError(msg, sig); !errors.Is(err, ErrInvalidBasePoint) {
+			t.Errorf("%v: got %v, want %v", sig.Scheme, err, ErrInvalidBasePoint)
+		}
+	}
+}
+
+func TestLinkableWithHashToCurve(t *testing.T) {
+	signer, _ := sm2.GenerateKey(rand.Reader)
+	participant, _ := sm2.GenerateKey(rand.Reader)
+	pubs := []*ecdsa.PublicKey{&participant.PublicKey, &signer.PublicKey}
+	msg1 := []byte("hello world")
+	msg2 := []byte("World Peace")
+
+	tests := []struct {
+		signer          RingSigner
+		legacySigner    RingSigner
+		verifier        RingVerifier
+		defaultVerifier RingVerifier
+	}{
+		{
+			NewBaseLinkableSigner(signer, pubs, WithHashToCurve()),
+			NewBaseLinkableSigner(signer, pubs),
+			NewBaseLinkableVerfier(pubs, WithHashToCurve()),
+			NewBaseLinkableVerfier(pubs),
+		},
+		{
+			NewLinkableSignerVariant1(signer, pubs, WithHashToCurve()),
+			NewLinkableSignerVariant1(signer, pubs),
+			NewLinkableVerfierVariant1(pubs, WithHashToCurve()),
+			NewLinkableVerfierVariant1(pubs),
+		},
+		{
+			NewLinkableSignerVariant2(signer, pubs, WithHashToCurve()),
+			NewLinkableSignerVariant2(signer, pubs),
+			NewLinkableVerfierVariant2(pubs, WithHashToCurve()),
+			NewLinkableVerfierVariant2(pubs),
+		},
+	}
+	for _, tt := range tests {
+		sig1, err := tt.signer.Sign(rand.Reader, SimpleParticipantRandInt, msg1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig2, err := tt.signer.Sign(rand.Reader, SM2ParticipantRandInt, msg2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !tt.verifier.Verify(msg1, sig1) || !tt.verifier.Verify(msg2, sig2) {
+			t.Errorf("%v: failed to verify the signature", sig1.Scheme)
+		}
+		if tt.defaultVerifier.Verify(msg1, sig1) {
+			t.Errorf("%v: verified with the default Hp", sig1.Scheme)
+		}
+		if !Linkable(sig1, sig2) {
+			t.Errorf("%v: failed to link", sig1.Scheme)
+		}
+		legacy, err := tt.legacySigner.Sign(rand.Reader, SimpleParticipantRandInt, msg1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if Linkable(sig1, legacy) {
+			t.Errorf("%v: key image does not depend on Hp", sig1.Scheme)
+		}
+	}
+}
+
+// legacyBaseStep and legacyVariantStep are the step arithmetic of the first
+// release, which BenchmarkLinkableStep compares linkableSteps against.
+func legacyBaseStep(pub *ecdsa.PublicKey, rx, ry, qx, qy, s, c *big.Int) (vx, vy, wx, wy *big.Int) {
+	vx, vy = legacyRingStep(pub, s, c)
+	sx, sy := pub.ScalarMult(rx, ry, s.Bytes())
+	wx, wy = pub.ScalarMult(qx, qy, c.Bytes())
+	wx, wy = pub.Add(sx, sy, wx, wy)
+	return
+}
+
+func legacyVariantStep(pub *ecdsa.PublicKey, rx, ry, qx, qy, s, c *big.Int) (vx, vy *big.Int) {
+	vx, vy = pub.Add(pub.X, pub.Y, qx, qy)
+	vx, vy = pub.ScalarMult(vx, vy, c.Bytes())
+	sx, sy := pub.ScalarMult(rx, ry, s.Bytes())
+	return pub.Add(sx, sy, vx, vy)
+}
+
+// newTestSteps returns the steps of a signature of the first member of a
+// ring of n members, with its base point and key image.
+func newTestSteps(t testing.TB, n int, prepare bool) (steps *linkableSteps, rx, ry, qx, qy *big.Int) {
+	t.Helper()
+	keys, ring := newTestRing(t, n)
+	var pr *PreparedRing
+	if prepare {
+		var err error
+		if pr, err = NewPreparedRing(ring); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rx, ry = ring.hashToCurvePoint()
+	qx, qy = sm2.P256().ScalarMult(rx, ry, keys[0].D.Bytes())
+	return newLinkableSteps(pr, ring, rx, ry, qx, qy), rx, ry, qx, qy
+}
+
+func TestLinkableSteps(t *testing.T) {
+	s, _ := randFieldElement(sm2.P256(), rand.Reader)
+	c, _ := randFieldElement(sm2.P256(), rand.Reader)
+	for _, n := range []int{2, fixedTableThreshold} {
+		for _, prepare := range []bool{false, true} {
+			steps, rx, ry, qx, qy := newTestSteps(t, n, prepare)
+			pub := steps.ring.PublicKey(1)
+			x1, y1, x2, y2 := steps.base(1, s, c)
+			wx1, wy1, wx2, wy2 := legacyBaseStep(pub, rx, ry, qx, qy, s, c)
+			if x1.Cmp(wx1) != 0 || y1.Cmp(wy1) != 0 || x2.Cmp(wx2) != 0 || y2.Cmp(wy2) != 0 {
+				t.Errorf("%d members, prepared %v: base step mismatch", n, prepare)
+			}
+			x1, y1 = steps.variant(1, s, c)
+			wx1, wy1 = legacyVariantStep(pub, rx, ry, qx, qy, s, c)
+			if x1.Cmp(wx1) != 0 || y1.Cmp(wy1) != 0 {
+				t.Errorf("%d members, prepared %v: variant step mismatch", n, prepare)
+			}
+		}
+	}
+}
+
+// BenchmarkLinkableStep measures the cost of a ring member in signing and
+// verification of linkable signatures. Large rings use tables of Hp and of
+// the key image, whose cost is spread over the members and not included.
+func BenchmarkLinkableStep(b *testing.B) {
+	s, _ := randFieldElement(sm2.P256(), rand.Reader)
+	c, _ := randFieldElement(sm2.P256(), rand.Reader)
+	small, rx, ry, qx, qy := newTestSteps(b, 2, false)
+	large, _, _, _, _ := newTestSteps(b, fixedTableThreshold, false)
+	prepared, _, _, _, _ := newTestSteps(b, fixedTableThreshold, true)
+	pub := small.ring.PublicKey(1)
+	b.Run("Base/Legacy", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			legacyBaseStep(pub, rx, ry, qx, qy, s, c)
+		}
+	})
+	b.Run("Variant/Legacy", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			legacyVariantStep(pub, rx, ry, qx, qy, s, c)
+		}
+	})
+	for _, tt := range []struct {
+		name  string
+		steps *linkableSteps
+	}{
+		{"SmallRing", small},
+		{"LargeRing", large},
+		{"Prepared", prepared},
+	} {
+		b.Run("Base/"+tt.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				tt.steps.base(1, s, c)
+			}
+		})
+		b.Run("Variant/"+tt.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				tt.steps.variant(1, s, c)
+			}
+		})
+	}
+}
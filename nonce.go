@@ -0,0 +1,139 @@
+package sm2rsign
+
+import (
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/emmansun/gmsm/sm2"
+	"github.com/emmansun/gmsm/sm3"
+)
+
+// nonceDomain separates the message digest of the nonce derivation from
+// other uses of SM3 in this package.
+const nonceDomain = "SM2RSIGN-V01-NONCE"
+
+var errNonceSourceRead = errors.New("sm2rsign: Deterministic and Hedged sources can only be passed to the signers of this package")
+
+// Deterministic returns a random source for the signers of this package,
+// such as Sign, SignRing and the linkable signers, which makes signing
+// deterministic. All the randomness of a signature, the signer's nonce k
+// and the scalars s_i of the other members, is then derived in the manner
+// of RFC 6979 with HMAC-SM3, keyed by the private key, from the ring ID,
+// the scheme, the linkability base point and the message.
+//
+// Deterministic signing does not depend on the quality of a random number
+// generator, but the same message signed twice over the same ring gives
+// the same signature, which links the two signatures. It is intended for
+// test vectors. Use Hedged in production.
+//
+// The source is recognized by the signers and can not be read itself, so
+// wrapping it in another reader makes signing fail instead of falling back
+// to other randomness.
+func Deterministic() io.Reader {
+	return nonceSource{}
+}
+
+// Hedged returns a random source for the signers of this package which
+// derives the randomness of a signature like Deterministic, but also mixes
+// 32 bytes read from rand into the derivation, as the additional data k' of
+// RFC 6979, section 3.6. The signatures are as unpredictable as with rand
+// itself, and the nonce stays secret even if rand repeats its output or is
+// biased.
+func Hedged(rand io.Reader) io.Reader {
+	return nonceSource{entropy: rand}
+}
+
+// nonceSource is the random source returned by Hedged, or Deterministic if
+// entropy is nil. It is replaced by a nonceReader when signing starts.
+type nonceSource struct {
+	entropy io.Reader
+}
+
+func (nonceSource) Read([]byte) (int, error) {
+	return 0, errNonceSourceRead
+}
+
+// signingReader returns the random source of a signature by priv over ring
+// with the given scheme, where hp is the linkability base point, or nil for
+// plain ring signatures. It is rand itself unless rand was returned by
+// Deterministic or Hedged.
+func signingReader(rand io.Reader, priv *sm2.PrivateKey, ring *Ring, scheme LinkableScheme, hp *affinePoint, msg []byte) (io.Reader, error) {
+	src, ok := rand.(nonceSource)
+	if !ok {
+		return rand, nil
+	}
+	// bits2octets(h), where h also binds the ring ID, the scheme and hp, so
+	// that signatures of the same message in different schemes or scopes
+	// never share a nonce
+	h := sm3.New()
+	h.Write([]byte(nonceDomain))
+	h.Write(ring.ID())
+	h.Write([]byte{byte(scheme)})
+	if hp != nil {
+		var b [64]byte
+		hp.x.FillBytes(b[:32])
+		hp.y.FillBytes(b[32:])
+		h.Write(b[:])
+	}
+	h.Write(msg)
+
+	// int2octets(d) || bits2octets(h) || k'
+	var buf [96]byte
+	defer clear(buf[:])
+	priv.D.FillBytes(buf[:32])
+	seed := h.Sum(buf[:32])
+	if src.entropy != nil {
+		if _, err := io.ReadFull(src.entropy, buf[64:]); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrRandomSource, err)
+		}
+		seed = buf[:]
+	}
+	return newNonceReader(seed), nil
+}
+
+// nonceReader is the HMAC_DRBG of RFC 6979, section 3.2, with SM3. Each
+// Read returns a candidate T of steps h.1 and h.2 and then updates K and V
+// as in step h.3, so the first 32 bytes read are the nonce of RFC 6979.
+type nonceReader struct {
+	k, v []byte
+}
+
+func newNonceReader(seed []byte) *nonceReader {
+	r := &nonceReader{k: make([]byte, sm3.Size), v: make([]byte, sm3.Size)}
+	for i := range r.v {
+		r.v[i] = 0x01
+	}
+	r.update(0x00, seed)
+	r.update(0x01, seed)
+	return r
+}
+
+func (r *nonceReader) mac() hash.Hash {
+	return hmac.New(sm3.New, r.k)
+}
+
+// update sets K = HMAC_K(V || b || data) and V = HMAC_K(V).
+func (r *nonceReader) update(b byte, data []byte) {
+	m := r.mac()
+	m.Write(r.v)
+	m.Write([]byte{b})
+	m.Write(data)
+	r.k = m.Sum(r.k[:0])
+	m = r.mac()
+	m.Write(r.v)
+	r.v = m.Sum(r.v[:0])
+}
+
+func (r *nonceReader) Read(p []byte) (int, error) {
+	for n := 0; n < len(p); {
+		m := r.mac()
+		m.Write(r.v)
+		r.v = m.Sum(r.v[:0])
+		n += copy(p[n:], r.v)
+	}
+	r.update(0x00, nil)
+	return len(p), nil
+}
@@ -0,0 +1,113 @@
+package sm2rsign
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/big"
+	"testing"
+
+	"github.com/emmansun/gmsm/sm2"
+)
+
+// deterministicSignature is the plain ring signature, in the compact binary
+// format, made with Deterministic by the second member of the ring of
+// legacyKeys over the message "deterministic signature".
+const deterministicSignature = "d55a58ba5b9edcc5455a1b3b6742211152d024b9dfb394b9889e43a9598679e3d77d7574a486707ce100ac33c04c8a1ee01cdf4ef1383ad716ad19262e69b33c7b6e179eb827239e611d23b1e229417495ee63262c9677df56f7ca6da5ee2d7c27ab663fb14840e3ac84b815dabd4cccf0981c72a51fbeb6c4ff62413ec3e13f"
+
+func TestDeterministicSignature(t *testing.T) {
+	pubs := legacyRing(t)
+	d, _ := new(big.Int).SetString(legacyKeys[1], 16)
+	priv, err := sm2.NewPrivateKeyFromInt(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("deterministic signature")
+	sig, err := Sign(Deterministic(), SimpleParticipantRandInt, priv, pubs, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := sig.MarshalBinary()
+	if hex.EncodeToString(got) != deterministicSignature {
+		t.Errorf("got %x", got)
+	}
+}
+
+func TestDeterministicSigning(t *testing.T) {
+	keys, ring := newTestRing(t, 3)
+	msg := []byte("hello world")
+	scope := []byte("epoch-42")
+	linkable := []struct {
+		signer   RingSigner
+		verifier errorVerifier
+	}{
+		{NewBaseLinkableSignerWithRing(keys[1], ring), NewBaseLinkableVerfierWithRing(ring)},
+		{NewLinkableSignerVariant1WithRing(keys[1], ring), NewLinkableVerfierVariant1WithRing(ring)},
+		{NewLinkableSignerVariant2WithRing(keys[1], ring), NewLinkableVerfierVariant2WithRing(ring)},
+		{NewScopedLinkableSignerWithRing(keys[1], ring, scope), NewScopedLinkableVerfierWithRing(ring, scope)},
+	}
+	// sign returns the encoding of a verified signature
+	sign := []func(rand io.Reader) ([]byte, error){
+		func(rand io.Reader) ([]byte, error) {
+			sig, err := SignRing(rand, SM2ParticipantRandInt, keys[1], ring, msg)
+			if err != nil {
+				return nil, err
+			}
+			if err := VerifyRingWithError(ring, msg, sig); err != nil {
+				return nil, err
+			}
+			return sig.MarshalASN1()
+		},
+	}
+	for _, tt := range linkable {
+		sign = append(sign, func(rand io.Reader) ([]byte, error) {
+			sig, err := tt.signer.Sign(rand, SM2ParticipantRandInt, msg)
+			if err != nil {
+				return nil, err
+			}
+			if err := tt.verifier.VerifyWithError(msg, sig); err != nil {
+				return nil, err
+			}
+			return sig.MarshalASN1()
+		})
+	}
+
+	for i, sign := range sign {
+		sig1, err := sign(Deterministic())
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		sig2, err := sign(Deterministic())
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if !bytes.Equal(sig1, sig2) {
+			t.Errorf("%d: deterministic signatures differ", i)
+		}
+		sig1, err = sign(Hedged(rand.Reader))
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		sig2, err = sign(Hedged(rand.Reader))
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if bytes.Equal(sig1, sig2) {
+			t.Errorf("%d: hedged signatures are equal", i)
+		}
+		if _, err := sign(Hedged(bytes.NewReader(nil))); !errors.Is(err, ErrRandomSource) {
+			t.Errorf("%d: got %v, want %v", i, err, ErrRandomSource)
+		}
+	}
+
+	if _, err := Deterministic().Read(make([]byte, 32)); err == nil {
+		t.Errorf("Deterministic can be read outside of the signers")
+	}
+	// a wrapped source is not recognized, signing fails instead of using it
+	if _, err := SignRing(bufio.NewReader(Deterministic()), SimpleParticipantRandInt, keys[1], ring, msg); !errors.Is(err, ErrRandomSource) {
+		t.Errorf("wrapped Deterministic: got %v, want %v", err, ErrRandomSource)
+	}
+}
@@ -0,0 +1,175 @@
+package sm2rsign
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/emmansun/gmsm/sm2"
+	"github.com/emmansun/gmsm/sm3"
+)
+
+// ParticipantContext describes the step of a ring member other than the
+// signer, for which a ParticipantStrategy draws the scalar s_i.
+type ParticipantContext struct {
+	// Ring is the ring the signature is made over. It must not be modified.
+	Ring *Ring
+	// Index is the position of the member in the ring.
+	Index int
+	// PublicKey is the public key of the member, Ring.PublicKey(Index).
+	PublicKey *ecdsa.PublicKey
+	// Scheme is the scheme of a linkable signature, or 0 for a plain ring
+	// signature.
+	Scheme LinkableScheme
+	// Message is the signed message. It must not be modified.
+	Message []byte
+}
+
+// ParticipantStrategy draws the scalars s_i of the ring members other than
+// the signer. The signers call it once per member, in the order of the ring
+// walk, which starts after the signer and wraps around. It should return a
+// scalar in [0, N), larger values are reduced modulo N.
+//
+// ParticipantRandInt implements ParticipantStrategy, so the strategies of
+// the earlier versions can be used where a ParticipantStrategy is expected.
+type ParticipantStrategy interface {
+	RandInt(rand io.Reader, ctx *ParticipantContext) (*big.Int, error)
+}
+
+// RandInt calls f(rand, ctx.PublicKey, ctx.Message).
+func (f ParticipantRandInt) RandInt(rand io.Reader, ctx *ParticipantContext) (*big.Int, error) {
+	return f(rand, ctx.PublicKey, ctx.Message)
+}
+
+// errNilScalar is returned for a strategy which returned neither a scalar
+// nor an error.
+var errNilScalar = fmt.Errorf("%w: participant strategy returned a nil scalar", ErrRandomSource)
+
+// participants draws the scalars of a signature with a strategy.
+type participants struct {
+	strategy ParticipantStrategy
+	ctx      ParticipantContext
+}
+
+func newParticipants(strategy ParticipantStrategy, ring *Ring, scheme LinkableScheme, msg []byte) *participants {
+	return &participants{strategy: strategy, ctx: ParticipantContext{Ring: ring, Scheme: scheme, Message: msg}}
+}
+
+// draw returns s_i of the i-th member, reduced modulo N.
+func (p *participants) draw(rand io.Reader, i int) (*big.Int, error) {
+	// every call gets its own context, strategies may keep it
+	ctx := p.ctx
+	ctx.Index = i
+	ctx.PublicKey = ctx.Ring.keys[i]
+	s, err := p.strategy.RandInt(rand, &ctx)
+	if err != nil {
+		return nil, participantError(i, err)
+	}
+	if s == nil {
+		return nil, participantError(i, errNilScalar)
+	}
+	// custom strategies may not reduce s, which the step arithmetic and the
+	// verifiers require
+	return s.Mod(s, sm2.P256().Params().N), nil
+}
+
+// seededParticipantsDomain separates the derivation of SeededParticipants
+// from other uses of HMAC-SM3 in this package.
+const seededParticipantsDomain = "SM2RSIGN-V01-PARTICIPANT"
+
+type seededParticipants struct {
+	seed []byte
+}
+
+// SeededParticipants returns a strategy which derives s_i with HMAC-SM3,
+// keyed by seed, from the ring ID, the scheme, the position of the member
+// and the message, ignoring the random source. Signing the same message
+// over the same ring again reuses the same s_i.
+//
+// Anyone who knows the seed can recompute the scalars of the other members
+// and find the signer, so the seed must be as secret as the private key.
+func SeededParticipants(seed []byte) ParticipantStrategy {
+	return seededParticipants{seed: append([]byte{}, seed...)}
+}
+
+func (p seededParticipants) RandInt(_ io.Reader, ctx *ParticipantContext) (*big.Int, error) {
+	N := sm2.P256().Params().N
+	var header [9]byte
+	header[0] = byte(ctx.Scheme)
+	binary.BigEndian.PutUint32(header[1:5], uint32(ctx.Index))
+	for counter := uint32(0); ; counter++ {
+		binary.BigEndian.PutUint32(header[5:], counter)
+		m := hmac.New(sm3.New, p.seed)
+		m.Write([]byte(seededParticipantsDomain))
+		m.Write(ctx.Ring.ID())
+		m.Write(header[:])
+		m.Write(ctx.Message)
+		s := new(big.Int).SetBytes(m.Sum(nil))
+		if s.Cmp(N) < 0 {
+			return s, nil
+		}
+	}
+}
+
+type sm2UIDParticipants struct {
+	uid func(pub *ecdsa.PublicKey) []byte
+}
+
+// SM2UIDParticipants returns a strategy which draws s_i like
+// SM2ParticipantRandInt, but hashes the message with the ZA value of the
+// user ID returned by uid for the member, instead of the default user ID.
+// If uid returns an empty user ID, the default one is used. If uid is nil,
+// the user IDs of the ring members are used, see NewRingWithMembers. When
+// presigning, the message is nil, see PresignRing.
+func SM2UIDParticipants(uid func(pub *ecdsa.PublicKey) []byte) ParticipantStrategy {
+	return sm2UIDParticipants{uid: uid}
+}
+
+func (p sm2UIDParticipants) RandInt(rand io.Reader, ctx *ParticipantContext) (*big.Int, error) {
+	if p.uid == nil {
+		return sm2ParticipantRandInt(rand, ctx.PublicKey, ctx.Message, ctx.Ring.UID(ctx.Index))
+	}
+	return sm2ParticipantRandInt(rand, ctx.PublicKey, ctx.Message, p.uid(ctx.PublicKey))
+}
+
+type uniformParticipants struct {
+	base ParticipantStrategy
+}
+
+// UniformParticipants returns a strategy whose scalars are distributed like
+// the response of the signer, uniformly in [0, N).
+//
+// The scalars of SimpleParticipantRandInt are uniform in [1, N) and those
+// of SM2ParticipantRandInt, x(k·G) + e + k, are never 0 and not uniform,
+// while the response of the signer can take any value. The differences are
+// far too small to be observed in practice, but UniformParticipants removes
+// them: it draws s_i with base, so the work per member stays the same, and
+// adds a uniformly random scalar to it.
+func UniformParticipants(base ParticipantStrategy) ParticipantStrategy {
+	return uniformParticipants{base: base}
+}
+
+func (p uniformParticipants) RandInt(rand io.Reader, ctx *ParticipantContext) (*big.Int, error) {
+	s, err := p.base.RandInt(rand, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return nil, errNilScalar
+	}
+	N := sm2.P256().Params().N
+	var b [32]byte
+	for {
+		if _, err := io.ReadFull(rand, b[:]); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrRandomSource, err)
+		}
+		u := new(big.Int).SetBytes(b[:])
+		if u.Cmp(N) < 0 {
+			s.Add(s, u)
+			return s.Mod(s, N), nil
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package sm2rsign
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	mathrand "math/rand/v2"
+	"testing"
+
+	"github.com/emmansun/gmsm/sm2"
+)
+
+// contextRecorder records the contexts it is called with.
+type contextRecorder struct {
+	contexts []*ParticipantContext
+}
+
+func (r *contextRecorder) RandInt(rand io.Reader, ctx *ParticipantContext) (*big.Int, error) {
+	r.contexts = append(r.contexts, ctx)
+	return SimpleParticipantRandInt(rand, ctx.PublicKey, ctx.Message)
+}
+
+func TestParticipantContext(t *testing.T) {
+	keys, ring := newTestRing(t, 4)
+	msg := []byte("hello world")
+	var rec contextRecorder
+	if _, err := NewLinkableSignerVariant1WithRing(keys[1], ring).SignWithStrategy(rand.Reader, &rec, msg); err != nil {
+		t.Fatal(err)
+	}
+	// the ring walk starts after the signer
+	want := []int{2, 3, 0}
+	if len(rec.contexts) != len(want) {
+		t.Fatalf("got %d calls, want %d", len(rec.contexts), len(want))
+	}
+	for i, ctx := range rec.contexts {
+		if ctx.Index != want[i] || !ctx.PublicKey.Equal(ring.PublicKey(want[i])) || ctx.Ring != ring ||
+			ctx.Scheme != LinkableSchemeVariant1 || !bytes.Equal(ctx.Message, msg) {
+			t.Errorf("unexpected context %+v", ctx)
+		}
+	}
+
+	rec.contexts = nil
+	if _, err := SignRingWithStrategy(rand.Reader, &rec, keys[0], ring, msg); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.contexts) != 3 || rec.contexts[0].Index != 1 || rec.contexts[0].Scheme != 0 {
+		t.Errorf("unexpected contexts")
+	}
+}
+
+// nilStrategy returns neither a scalar nor an error.
+type nilStrategy struct{}
+
+func (nilStrategy) RandInt(io.Reader, *ParticipantContext) (*big.Int, error) {
+	return nil, nil
+}
+
+func TestNilParticipantScalar(t *testing.T) {
+	keys, ring := newTestRing(t, 3)
+	msg := []byte("hello world")
+	for _, strategy := range []ParticipantStrategy{nilStrategy{}, UniformParticipants(nilStrategy{})} {
+		if _, err := SignRingWithStrategy(rand.Reader, strategy, keys[0], ring, msg); !errors.Is(err, ErrRandomSource) {
+			t.Errorf("%T: got %v, want ErrRandomSource", strategy, err)
+		}
+		if _, err := NewBaseLinkableSignerWithRing(keys[0], ring).SignWithStrategy(rand.Reader, strategy, msg); !errors.Is(err, ErrRandomSource) {
+			t.Errorf("%T: linkable: got %v, want ErrRandomSource", strategy, err)
+		}
+	}
+}
+
+func TestParticipantStrategies(t *testing.T) {
+	keys, ring := newTestRing(t, 3)
+	msg := []byte("hello world")
+	alice := ring.PublicKey(0)
+	uid := func(pub *ecdsa.PublicKey) []byte {
+		if pub.Equal(alice) {
+			return []byte("alice@example.com")
+		}
+		return nil
+	}
+	strategies := []ParticipantStrategy{
+		ParticipantRandInt(SimpleParticipantRandInt),
+		ParticipantRandInt(SM2ParticipantRandInt),
+		SeededParticipants([]byte("seed")),
+		SM2UIDParticipants(uid),
+		UniformParticipants(ParticipantRandInt(SimpleParticipantRandInt)),
+		UniformParticipants(ParticipantRandInt(SM2ParticipantRandInt)),
+	}
+	for i, strategy := range strategies {
+		sig, err := SignRingWithStrategy(rand.Reader, strategy, keys[1], ring, msg)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if err := VerifyRingWithError(ring, msg, sig); err != nil {
+			t.Errorf("%d: %v", i, err)
+		}
+		lsig, err := NewBaseLinkableSignerWithRing(keys[1], ring).SignWithStrategy(rand.Reader, strategy, msg)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if err := NewBaseLinkableVerfierWithRing(ring).VerifyWithError(msg, lsig); err != nil {
+			t.Errorf("%d: %v", i, err)
+		}
+	}
+
+	// the seeded scalars only depend on the seed and the signing context
+	seeded := SeededParticipants([]byte("seed"))
+	sig1, _ := SignRingWithStrategy(rand.Reader, seeded, keys[1], ring, msg)
+	sig2, _ := SignRingWithStrategy(rand.Reader, seeded, keys[1], ring, msg)
+	if sig1.S[0].Cmp(sig2.S[0]) != 0 || sig1.S[2].Cmp(sig2.S[2]) != 0 || sig1.S[0].Cmp(sig1.S[2]) == 0 {
+		t.Errorf("unexpected seeded scalars")
+	}
+	sig2, _ = SignRingWithStrategy(rand.Reader, SeededParticipants([]byte("another seed")), keys[1], ring, msg)
+	if sig1.S[0].Cmp(sig2.S[0]) == 0 {
+		t.Errorf("seeded scalars do not depend on the seed")
+	}
+
+	// only the scalar of the member with a user ID changes
+	sig1, _ = SignRingWithStrategy(mathrand.NewChaCha8([32]byte{}), ParticipantRandInt(SM2ParticipantRandInt), keys[1], ring, msg)
+	sig2, _ = SignRingWithStrategy(mathrand.NewChaCha8([32]byte{}), SM2UIDParticipants(uid), keys[1], ring, msg)
+	if sig1.S[0].Cmp(sig2.S[0]) == 0 || sig1.S[2].Cmp(sig2.S[2]) != 0 {
+		t.Errorf("unexpected SM2 scalars with user IDs")
+	}
+
+	// the strategy errors are wrapped
+	if _, err := SignRingWithStrategy(bytes.NewReader(make([]byte, 64)), UniformParticipants(seeded), keys[1], ring, msg); !errors.Is(err, ErrRandomSource) {
+		t.Errorf("got %v, want %v", err, ErrRandomSource)
+	}
+}
+
+// chiSquare returns the chi-square statistics of the top and the low four
+// bits of a and b, which test whether the samples a and b come from the
+// same distribution. If b is nil, a is tested against the uniform
+// distribution instead. Both statistics have 15 degrees of freedom.
+func chiSquare(a, b []*big.Int) (top, low float64) {
+	buckets := func(scalars []*big.Int) (top, low [16]float64) {
+		for _, s := range scalars {
+			top[new(big.Int).Rsh(s, 252).Uint64()]++
+			low[s.Bits()[0]&15]++
+		}
+		return
+	}
+	stat := func(x, y [16]float64, nx, ny float64) float64 {
+		var chi float64
+		for i := range x {
+			if x[i]+y[i] == 0 {
+				continue
+			}
+			d := x[i]*math.Sqrt(ny/nx) - y[i]*math.Sqrt(nx/ny)
+			chi += d * d / (x[i] + y[i])
+		}
+		return chi
+	}
+	aTop, aLow := buckets(a)
+	if b == nil {
+		var expected [16]float64
+		for i := range expected {
+			expected[i] = float64(len(a)) / 16
+		}
+		var chiTop, chiLow float64
+		for i := range expected {
+			chiTop += (aTop[i] - expected[i]) * (aTop[i] - expected[i]) / expected[i]
+			chiLow += (aLow[i] - expected[i]) * (aLow[i] - expected[i]) / expected[i]
+		}
+		return chiTop, chiLow
+	}
+	bTop, bLow := buckets(b)
+	na, nb := float64(len(a)), float64(len(b))
+	return stat(aTop, bTop, na, nb), stat(aLow, bLow, na, nb)
+}
+
+// chiSquareLimit is the 0.999 quantile of the chi-square distribution with
+// 15 degrees of freedom.
+const chiSquareLimit = 37.7
+
+// testIndistinguishable signs many messages with strategy and checks that
+// neither the responses of the signer nor the scalars of the other members
+// deviate from the uniform distribution, and that the two can not be told
+// apart. The keys and the random source are fixed, so the test is
+// reproducible.
+func testIndistinguishable(t *testing.T, strategy ParticipantStrategy, signatures int) {
+	t.Helper()
+	d, _ := new(big.Int).SetString(legacyKeys[1], 16)
+	priv, err := sm2.NewPrivateKeyFromInt(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring, err := NewRing(legacyRing(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	random := mathrand.NewChaCha8([32]byte{1})
+	var signer, others []*big.Int
+	for i := 0; i < signatures; i++ {
+		sig, err := SignRingWithStrategy(random, strategy, priv, ring, []byte(fmt.Sprintf("message %d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer = append(signer, sig.S[1])
+		others = append(others, sig.S[0], sig.S[2])
+	}
+	for _, tt := range []struct {
+		name string
+		a, b []*big.Int
+	}{
+		{"signer", signer, nil},
+		{"participants", others, nil},
+		{"signer vs participants", signer, others},
+	} {
+		top, low := chiSquare(tt.a, tt.b)
+		if top > chiSquareLimit || low > chiSquareLimit {
+			t.Errorf("%s: chi-square %.1f (top bits), %.1f (low bits), limit %.1f", tt.name, top, low, chiSquareLimit)
+		}
+	}
+}
+
+func TestParticipantIndistinguishability(t *testing.T) {
+	if testing.Short() {
+		t.Skip("statistical test")
+	}
+	strategies := []struct {
+		name     string
+		strategy ParticipantStrategy
+	}{
+		{"Simple", ParticipantRandInt(SimpleParticipantRandInt)},
+		{"SM2", ParticipantRandInt(SM2ParticipantRandInt)},
+		{"Seeded", SeededParticipants([]byte("seed"))},
+		{"UniformSimple", UniformParticipants(ParticipantRandInt(SimpleParticipantRandInt))},
+		{"UniformSM2", UniformParticipants(ParticipantRandInt(SM2ParticipantRandInt))},
+	}
+	for _, tt := range strategies {
+		t.Run(tt.name, func(t *testing.T) {
+			testIndistinguishable(t, tt.strategy, 600)
+		})
+	}
+}
+
+// TestChiSquare checks that the harness detects a biased strategy.
+func TestChiSquare(t *testing.T) {
+	random := mathrand.New(mathrand.NewChaCha8([32]byte{2}))
+	N := sm2.P256().Params().N
+	var uniform, biased []*big.Int
+	for i := 0; i < 1200; i++ {
+		var b [32]byte
+		for j := range b {
+			b[j] = byte(random.Uint32())
+		}
+		s := new(big.Int).SetBytes(b[:])
+		uniform = append(uniform, new(big.Int).Mod(s, N))
+		// the low bit of every other scalar is cleared
+		if i%2 == 0 {
+			s.SetBit(s, 0, 0)
+		}
+		biased = append(biased, s.Mod(s, N))
+	}
+	if top, low := chiSquare(uniform, nil); top > chiSquareLimit || low > chiSquareLimit {
+		t.Errorf("uniform scalars rejected: %.1f, %.1f", top, low)
+	}
+	if _, low := chiSquare(biased, uniform); low <= chiSquareLimit {
+		t.Errorf("biased scalars accepted: %.1f", low)
+	}
+}
@@ -0,0 +1,269 @@
+package sm2rsign
+
+import (
+	"math/big"
+
+	"github.com/emmansun/gmsm/sm2"
+)
+
+// affinePoint is an SM2 curve point in affine coordinates. It can not
+// represent the point at infinity.
+type affinePoint struct {
+	x, y fieldElement
+}
+
+// point is an SM2 curve point in Jacobian coordinates (X/Z², Y/Z³). The
+// point at infinity has Z = 0.
+//
+// The group operations below branch on their inputs, so they are only used
+// with public points and scalars.
+type point struct {
+	x, y, z fieldElement
+}
+
+// feB is the curve parameter b in the Montgomery domain.
+var feB = func() fieldElement {
+	var b fieldElement
+	b.SetBig(sm2.P256().Params().B)
+	return b
+}()
+
+// newAffinePoint returns the point (x, y), or false if it is not on the
+// curve. (0, 0) is not accepted.
+func newAffinePoint(x, y *big.Int) (*affinePoint, bool) {
+	p := new(affinePoint)
+	if !p.x.SetBig(x) || !p.y.SetBig(y) {
+		return nil, false
+	}
+	// y² = x³ - 3x + b
+	var lhs, rhs, t fieldElement
+	lhs.Square(&p.y)
+	rhs.Square(&p.x)
+	rhs.Mul(&rhs, &p.x)
+	t.Add(&p.x, &p.x)
+	t.Add(&t, &p.x)
+	rhs.Sub(&rhs, &t)
+	rhs.Add(&rhs, &feB)
+	if lhs.Equal(&rhs) != 1 {
+		return nil, false
+	}
+	return p, true
+}
+
+// Big returns the coordinates of p.
+func (p *affinePoint) Big() (x, y *big.Int) {
+	return p.x.Big(), p.y.Big()
+}
+
+// setInfinity sets p to the point at infinity.
+func (p *point) setInfinity() *point {
+	*p = point{}
+	return p
+}
+
+func (p *point) isInfinity() bool {
+	return p.z.IsZero() == 1
+}
+
+// setBig sets p = (x, y), where (x, y) is a point on the curve or (0, 0)
+// for the point at infinity.
+func (p *point) setBig(x, y *big.Int) *point {
+	return p.setInfinity().addBig(x, y)
+}
+
+// addBig sets p = p + (x, y), where (x, y) is a point on the curve or
+// (0, 0) for the point at infinity.
+func (p *point) addBig(x, y *big.Int) *point {
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return p
+	}
+	var q affinePoint
+	q.x.SetBig(x)
+	q.y.SetBig(y)
+	return p.addAffine(p, &q)
+}
+
+// setAffine sets p = q.
+func (p *point) setAffine(q *affinePoint) *point {
+	p.x, p.y, p.z = q.x, q.y, feOne
+	return p
+}
+
+// affine returns the affine coordinates of p, or (0, 0) for the point at
+// infinity, which is how the point at infinity is encoded in the challenge
+// hashes and in the base point caches of Ring. The inversion is done with
+// math/big, which is much faster than Invert but not constant time, so p
+// must be public.
+func (p *point) affine() (x, y *big.Int) {
+	if p.isInfinity() {
+		return new(big.Int), new(big.Int)
+	}
+	var zInv, zInv2, ax, ay fieldElement
+	z := p.z.Big()
+	zInv.SetBig(z.ModInverse(z, sm2.P256().Params().P))
+	zInv2.Square(&zInv)
+	ax.Mul(&p.x, &zInv2)
+	zInv2.Mul(&zInv2, &zInv)
+	ay.Mul(&p.y, &zInv2)
+	return ax.Big(), ay.Big()
+}
+
+// double sets p = 2q, using dbl-2001-b for a = -3.
+func (p *point) double(q *point) *point {
+	var delta, gamma, beta, alpha, t, u fieldElement
+	delta.Square(&q.z)
+	gamma.Square(&q.y)
+	beta.Mul(&q.x, &gamma)
+	// alpha = 3·(X - delta)·(X + delta)
+	t.Sub(&q.x, &delta)
+	u.Add(&q.x, &delta)
+	alpha.Mul(&t, &u)
+	t.Add(&alpha, &alpha)
+	alpha.Add(&alpha, &t)
+	// Z3 = (Y + Z)² - gamma - delta
+	t.Add(&q.y, &q.z)
+	t.Square(&t)
+	t.Sub(&t, &gamma)
+	p.z.Sub(&t, &delta)
+	// X3 = alpha² - 8·beta
+	beta.Add(&beta, &beta)
+	beta.Add(&beta, &beta)
+	t.Add(&beta, &beta)
+	u.Square(&alpha)
+	p.x.Sub(&u, &t)
+	// Y3 = alpha·(4·beta - X3) - 8·gamma²
+	t.Sub(&beta, &p.x)
+	t.Mul(&alpha, &t)
+	gamma.Square(&gamma)
+	gamma.Add(&gamma, &gamma)
+	gamma.Add(&gamma, &gamma)
+	gamma.Add(&gamma, &gamma)
+	p.y.Sub(&t, &gamma)
+	return p
+}
+
+// add sets p = q + r, using add-2007-bl.
+func (p *point) add(q, r *point) *point {
+	if q.isInfinity() {
+		*p = *r
+		return p
+	}
+	if r.isInfinity() {
+		*p = *q
+		return p
+	}
+	var z1z1, z2z2, u1, u2, s1, s2, h, i, j, rr, v fieldElement
+	z1z1.Square(&q.z)
+	z2z2.Square(&r.z)
+	u1.Mul(&q.x, &z2z2)
+	u2.Mul(&r.x, &z1z1)
+	s1.Mul(&q.y, &r.z)
+	s1.Mul(&s1, &z2z2)
+	s2.Mul(&r.y, &q.z)
+	s2.Mul(&s2, &z1z1)
+	h.Sub(&u2, &u1)
+	rr.Sub(&s2, &s1)
+	if h.IsZero() == 1 {
+		if rr.IsZero() == 1 {
+			return p.double(q)
+		}
+		return p.setInfinity()
+	}
+	rr.Add(&rr, &rr)
+	i.Add(&h, &h)
+	i.Square(&i)
+	j.Mul(&h, &i)
+	v.Mul(&u1, &i)
+	// Z3 = ((Z1 + Z2)² - Z1Z1 - Z2Z2)·H
+	p.z.Add(&q.z, &r.z)
+	p.z.Square(&p.z)
+	p.z.Sub(&p.z, &z1z1)
+	p.z.Sub(&p.z, &z2z2)
+	p.z.Mul(&p.z, &h)
+	// X3 = r² - J - 2·V
+	p.x.Square(&rr)
+	p.x.Sub(&p.x, &j)
+	p.x.Sub(&p.x, &v)
+	p.x.Sub(&p.x, &v)
+	// Y3 = r·(V - X3) - 2·S1·J
+	v.Sub(&v, &p.x)
+	v.Mul(&rr, &v)
+	s1.Mul(&s1, &j)
+	s1.Add(&s1, &s1)
+	p.y.Sub(&v, &s1)
+	return p
+}
+
+// addAffine sets p = q + r, using madd-2007-bl.
+func (p *point) addAffine(q *point, r *affinePoint) *point {
+	if q.isInfinity() {
+		return p.setAffine(r)
+	}
+	var z1z1, u2, s2, h, hh, i, j, rr, v fieldElement
+	z1z1.Square(&q.z)
+	u2.Mul(&r.x, &z1z1)
+	s2.Mul(&r.y, &q.z)
+	s2.Mul(&s2, &z1z1)
+	h.Sub(&u2, &q.x)
+	rr.Sub(&s2, &q.y)
+	if h.IsZero() == 1 {
+		if rr.IsZero() == 1 {
+			return p.double(q)
+		}
+		return p.setInfinity()
+	}
+	rr.Add(&rr, &rr)
+	hh.Square(&h)
+	i.Add(&hh, &hh)
+	i.Add(&i, &i)
+	j.Mul(&h, &i)
+	v.Mul(&q.x, &i)
+	// Z3 = (Z1 + H)² - Z1Z1 - HH
+	p.z.Add(&q.z, &h)
+	p.z.Square(&p.z)
+	p.z.Sub(&p.z, &z1z1)
+	p.z.Sub(&p.z, &hh)
+	// Y3 = r·(V - X3) - 2·Y1·J, computed before X1 may be overwritten
+	var y1j fieldElement
+	y1j.Mul(&q.y, &j)
+	y1j.Add(&y1j, &y1j)
+	// X3 = r² - J - 2·V
+	p.x.Square(&rr)
+	p.x.Sub(&p.x, &j)
+	p.x.Sub(&p.x, &v)
+	p.x.Sub(&p.x, &v)
+	v.Sub(&v, &p.x)
+	v.Mul(&rr, &v)
+	p.y.Sub(&v, &y1j)
+	return p
+}
+
+// batchAffine converts points, none of which may be the point at infinity,
+// to affine coordinates with a single field inversion.
+func batchAffine(points []point) []affinePoint {
+	if len(points) == 0 {
+		return nil
+	}
+	// prods[i] = Z_0·...·Z_i
+	prods := make([]fieldElement, len(points))
+	prods[0] = points[0].z
+	for i := 1; i < len(points); i++ {
+		prods[i].Mul(&prods[i-1], &points[i].z)
+	}
+	var inv, zInv, zInv2 fieldElement
+	inv.Invert(&prods[len(prods)-1])
+	out := make([]affinePoint, len(points))
+	for i := len(points) - 1; i >= 0; i-- {
+		if i > 0 {
+			zInv.Mul(&inv, &prods[i-1])
+			inv.Mul(&inv, &points[i].z)
+		} else {
+			zInv = inv
+		}
+		zInv2.Square(&zInv)
+		out[i].x.Mul(&points[i].x, &zInv2)
+		zInv2.Mul(&zInv2, &zInv)
+		out[i].y.Mul(&points[i].y, &zInv2)
+	}
+	return out
+}
@@ -0,0 +1,243 @@
+package sm2rsign
+
+import (
+	"crypto/rand"
+	"math/big"
+	"testing"
+
+	"github.com/emmansun/gmsm/sm2"
+)
+
+func TestFieldElement(t *testing.T) {
+	p := sm2.P256().Params().P
+	for i := 0; i < 100; i++ {
+		a, _ := rand.Int(rand.Reader, p)
+		b, _ := rand.Int(rand.Reader, p)
+		var x, y, z fieldElement
+		x.SetBig(a)
+		y.SetBig(b)
+
+		want := new(big.Int).Mul(a, b)
+		if z.Mul(&x, &y).Big().Cmp(want.Mod(want, p)) != 0 {
+			t.Fatalf("Mul mismatch")
+		}
+		want.Add(a, b)
+		if z.Add(&x, &y).Big().Cmp(want.Mod(want, p)) != 0 {
+			t.Fatalf("Add mismatch")
+		}
+		want.Sub(a, b)
+		if z.Sub(&x, &y).Big().Cmp(want.Mod(want, p)) != 0 {
+			t.Fatalf("Sub mismatch")
+		}
+		if a.Sign() != 0 && z.Invert(&x).Big().Cmp(new(big.Int).ModInverse(a, p)) != 0 {
+			t.Fatalf("Invert mismatch")
+		}
+	}
+	var z fieldElement
+	if z.SetBig(p) || z.SetBig(big.NewInt(-1)) {
+		t.Errorf("accepted an out of range value")
+	}
+}
+
+func TestScalar(t *testing.T) {
+	N := sm2.P256().Params().N
+	for i := 0; i < 100; i++ {
+		a, _ := rand.Int(rand.Reader, N)
+		b, _ := rand.Int(rand.Reader, N)
+		var x, y, z scalar
+		x.SetBig(a)
+		y.SetBig(b)
+
+		want := new(big.Int).Mul(a, b)
+		if z.Mul(&x, &y).Big().Cmp(want.Mod(want, N)) != 0 {
+			t.Fatalf("Mul mismatch")
+		}
+		want.Add(a, b)
+		if z.Add(&x, &y).Big().Cmp(want.Mod(want, N)) != 0 {
+			t.Fatalf("Add mismatch")
+		}
+		want.Sub(a, b)
+		if z.Sub(&x, &y).Big().Cmp(want.Mod(want, N)) != 0 {
+			t.Fatalf("Sub mismatch")
+		}
+		if a.Sign() != 0 && z.Invert(&x).Big().Cmp(new(big.Int).ModInverse(a, N)) != 0 {
+			t.Fatalf("Invert mismatch")
+		}
+
+		// s = (k - c·d)·(1 + d)^-1
+		d, _ := rand.Int(rand.Reader, N)
+		s, err := signerResponse(a, b, d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want.Mul(b, d)
+		want.Sub(a, want)
+		want.Mul(want, new(big.Int).ModInverse(new(big.Int).Add(d, one), N))
+		if s.Cmp(want.Mod(want, N)) != 0 {
+			t.Fatalf("signerResponse mismatch")
+		}
+	}
+	var z scalar
+	if z.SetBig(N) || z.SetBig(big.NewInt(-1)) {
+		t.Errorf("accepted an out of range value")
+	}
+
+	k, _ := rand.Int(rand.Reader, N)
+	zeroBig(k)
+	if k.Sign() != 0 {
+		t.Errorf("zeroBig did not clear the value")
+	}
+}
+
+func TestCombTable(t *testing.T) {
+	curve := sm2.P256()
+	params := curve.Params()
+	key, _ := sm2.GenerateKey(rand.Reader)
+	p, ok := newAffinePoint(key.X, key.Y)
+	if !ok {
+		t.Fatal("valid point rejected")
+	}
+	if _, ok := newAffinePoint(key.X, new(big.Int).Add(key.Y, one)); ok {
+		t.Errorf("invalid point accepted")
+	}
+	table := newCombTable(p, memberTableWidth)
+	gTable := baseTable()
+
+	nMinus1 := new(big.Int).Sub(params.N, one)
+	scalars := []*big.Int{big.NewInt(0), big.NewInt(1), big.NewInt(8), big.NewInt(9), nMinus1}
+	for i := 0; i < 20; i++ {
+		k, _ := rand.Int(rand.Reader, params.N)
+		scalars = append(scalars, k)
+	}
+	for _, k := range scalars {
+		var acc point
+		table.mulAdd(&acc, k)
+		x, y := acc.affine()
+		wantX, wantY := curve.ScalarMult(key.X, key.Y, k.Bytes())
+		if x.Cmp(wantX) != 0 || y.Cmp(wantY) != 0 {
+			t.Errorf("%v·P mismatch", k)
+		}
+
+		// k·G + (N-1-k)·G = -G
+		gTable.mulAdd(acc.setInfinity(), k)
+		gTable.mulAdd(&acc, new(big.Int).Sub(nMinus1, k))
+		x, y = acc.affine()
+		if x.Cmp(params.Gx) != 0 || y.Cmp(new(big.Int).Sub(params.P, params.Gy)) != 0 {
+			t.Errorf("%v·G + (N-1-%v)·G mismatch", k, k)
+		}
+		// k·G + (N-k)·G = O
+		gTable.mulAdd(acc.setInfinity(), k)
+		gTable.mulAdd(&acc, new(big.Int).Sub(params.N, k))
+		if x, y = acc.affine(); x.Sign() != 0 || y.Sign() != 0 {
+			t.Errorf("%v·G + (N-%v)·G is not the point at infinity", k, k)
+		}
+	}
+}
+
+func TestScalarMult(t *testing.T) {
+	params := sm2.P256().Params()
+	key, _ := sm2.GenerateKey(rand.Reader)
+	p, _ := newAffinePoint(key.X, key.Y)
+	table := newCombTable(p, memberTableWidth)
+
+	nMinus1 := new(big.Int).Sub(params.N, one)
+	scalars := []*big.Int{big.NewInt(1), big.NewInt(2), nMinus1}
+	for i := 0; i < 20; i++ {
+		k, _ := rand.Int(rand.Reader, params.N)
+		scalars = append(scalars, k)
+	}
+	for _, k := range scalars {
+		var got, want point
+		gotX, gotY := got.scalarMult(p, k).affine()
+		table.mulAdd(&want, k)
+		wantX, wantY := want.affine()
+		if gotX.Cmp(wantX) != 0 || gotY.Cmp(wantY) != 0 {
+			t.Errorf("%v·P mismatch", k)
+		}
+		gotX, gotY = got.scalarBaseMult(k).affine()
+		baseTable().mulAdd(want.setInfinity(), k)
+		wantX, wantY = want.affine()
+		if gotX.Cmp(wantX) != 0 || gotY.Cmp(wantY) != 0 {
+			t.Errorf("%v·G mismatch", k)
+		}
+		// k·P + (N-k)·P = O, with an explicit point at infinity
+		var rest point
+		if !got.scalarMult(p, k).add(&got, rest.scalarMult(p, new(big.Int).Sub(params.N, k))).isInfinity() {
+			t.Errorf("%v·P + (N-%v)·P is not the point at infinity", k, k)
+		}
+	}
+
+	k, _ := rand.Int(rand.Reader, params.N)
+	var got, want point
+	if _, err := got.scalarMultSecret(p, k); err != nil {
+		t.Fatal(err)
+	}
+	gotX, gotY := got.affine()
+	wantX, wantY := want.scalarMult(p, k).affine()
+	if gotX.Cmp(wantX) != 0 || gotY.Cmp(wantY) != 0 {
+		t.Errorf("blinded %v·P mismatch", k)
+	}
+	gotX, gotY, err := secretMult(nil, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantX, wantY = want.scalarBaseMult(k).affine()
+	if gotX.Cmp(wantX) != 0 || gotY.Cmp(wantY) != 0 {
+		t.Errorf("blinded %v·G mismatch", k)
+	}
+
+	if !got.scalarMult(p, new(big.Int)).isInfinity() || !got.scalarBaseMult(new(big.Int)).isInfinity() {
+		t.Errorf("0·P is not the point at infinity")
+	}
+	if _, err := basePoint(new(big.Int), new(big.Int)); err != ErrInvalidBasePoint {
+		t.Errorf("got %v, want %v", err, ErrInvalidBasePoint)
+	}
+	if _, err := basePoint(params.Gx, params.Gy); err != nil {
+		t.Errorf("valid base point rejected: %v", err)
+	}
+}
+
+func BenchmarkFieldMul(b *testing.B) {
+	var x, y fieldElement
+	x.SetBig(sm2.P256().Params().Gx)
+	y.SetBig(sm2.P256().Params().Gy)
+	for i := 0; i < b.N; i++ {
+		x.Mul(&x, &y)
+	}
+}
+
+func BenchmarkCombMul(b *testing.B) {
+	params := sm2.P256().Params()
+	g, _ := newAffinePoint(params.Gx, params.Gy)
+	table := newCombTable(g, memberTableWidth)
+	k, _ := rand.Int(rand.Reader, params.N)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var acc point
+		table.mulAdd(&acc, k)
+	}
+}
+
+func BenchmarkNewCombTable(b *testing.B) {
+	params := sm2.P256().Params()
+	g, _ := newAffinePoint(params.Gx, params.Gy)
+	for i := 0; i < b.N; i++ {
+		newCombTable(g, memberTableWidth)
+	}
+}
+
+func BenchmarkFieldInvert(b *testing.B) {
+	var x fieldElement
+	x.SetBig(sm2.P256().Params().Gx)
+	for i := 0; i < b.N; i++ {
+		x.Invert(&x)
+	}
+}
+
+func BenchmarkFieldSquare(b *testing.B) {
+	var x fieldElement
+	x.SetBig(sm2.P256().Params().Gx)
+	for i := 0; i < b.N; i++ {
+		x.Square(&x)
+	}
+}
@@ -0,0 +1,112 @@
+package sm2rsign
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Policy failures, see VerifierPolicy.
+var (
+	ErrRingTooLarge    = errors.New("sm2rsign: ring is larger than the verifier policy allows")
+	ErrMessageTooLarge = errors.New("sm2rsign: message is larger than the verifier policy allows")
+)
+
+// ContextVerifier is a RingVerifier which reports why verification failed
+// and can be cancelled. The linkable verifiers of this package implement it.
+type ContextVerifier interface {
+	RingVerifier
+	VerifyContext(ctx context.Context, msg []byte, signature *LinkableRingSignature) error
+}
+
+// VerifierPolicy bounds the resources a single verification may use, for
+// verifiers of untrusted input. The work of a verification grows linearly
+// with the ring size, and hashing with the message size. The zero value has
+// no limits.
+type VerifierPolicy struct {
+	// MaxRingSize is the maximum number of members of the ring and of
+	// scalars s_i of the signature, if positive.
+	MaxRingSize int
+	// MaxMessageSize is the maximum length of the message in bytes, if
+	// positive.
+	MaxMessageSize int
+	// Timeout bounds the duration of a verification, if positive. The
+	// verification returns context.DeadlineExceeded when it expires, which
+	// is checked between the steps of the ring.
+	Timeout time.Duration
+}
+
+// check returns an error wrapping ErrRingTooLarge or ErrMessageTooLarge if
+// a ring, a signature or a message exceeds the limits of the policy.
+func (p *VerifierPolicy) check(ringSize, signatureSize, msgSize int) error {
+	if p.MaxRingSize > 0 {
+		if ringSize > p.MaxRingSize {
+			return fmt.Errorf("%w: %d members", ErrRingTooLarge, ringSize)
+		}
+		if signatureSize > p.MaxRingSize {
+			return fmt.Errorf("%w: signature for %d members", ErrRingTooLarge, signatureSize)
+		}
+	}
+	if p.MaxMessageSize > 0 && msgSize > p.MaxMessageSize {
+		return fmt.Errorf("%w: %d bytes", ErrMessageTooLarge, msgSize)
+	}
+	return nil
+}
+
+// context returns ctx with the timeout of the policy, if any.
+func (p *VerifierPolicy) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.Timeout > 0 {
+		return context.WithTimeout(ctx, p.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// VerifyRing checks the limits of the policy before doing any curve
+// arithmetic, then verifies the ring signature like VerifyRingContext. The
+// slice-based Verify and VerifyWithError have no policy: make a Ring of the
+// public keys with NewRing first.
+func (p *VerifierPolicy) VerifyRing(ctx context.Context, ring *Ring, msg []byte, signature *RingSignature) error {
+	if ring == nil {
+		return ErrRingTooSmall
+	}
+	if err := p.check(ring.Len(), signature.RingSize(), len(msg)); err != nil {
+		return err
+	}
+	ctx, cancel := p.context(ctx)
+	defer cancel()
+	return VerifyRingContext(ctx, ring, msg, signature)
+}
+
+// VerifyPrepared is like VerifyRing, but verifies like VerifyPreparedContext.
+func (p *VerifierPolicy) VerifyPrepared(ctx context.Context, ring *PreparedRing, msg []byte, signature *RingSignature) error {
+	if ring == nil {
+		return ErrRingTooSmall
+	}
+	if err := p.check(ring.ring.Len(), signature.RingSize(), len(msg)); err != nil {
+		return err
+	}
+	ctx, cancel := p.context(ctx)
+	defer cancel()
+	return VerifyPreparedContext(ctx, ring, msg, signature)
+}
+
+// Verify checks the limits of the policy before doing any curve arithmetic,
+// then verifies the linkable ring signature with the VerifyContext method of
+// v. The size of the ring is only checked for the verifiers of this package,
+// other verifiers get signatures of at most MaxRingSize members.
+func (p *VerifierPolicy) Verify(ctx context.Context, v ContextVerifier, msg []byte, signature *LinkableRingSignature) error {
+	ringSize := 0
+	if pv, ok := v.(preparableVerifier); ok {
+		// the ring of a zero-value verifier is nil
+		if ring, _ := pv.preparation(); ring != nil {
+			ringSize = ring.Len()
+		}
+	}
+	if err := p.check(ringSize, signature.RingSize(), len(msg)); err != nil {
+		return err
+	}
+	ctx, cancel := p.context(ctx)
+	defer cancel()
+	return v.VerifyContext(ctx, msg, signature)
+}
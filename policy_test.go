@@ -0,0 +1,224 @@
+package sm2rsign
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// countingContext is a context which is cancelled after its Err method has
+// been called a number of times.
+type countingContext struct {
+	context.Context
+	calls int
+}
+
+func (c *countingContext) Err() error {
+	if c.calls--; c.calls < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+// blockingVerifier waits until verification is cancelled.
+type blockingVerifier struct{}
+
+func (blockingVerifier) Verify(msg []byte, signature *LinkableRingSignature) bool {
+	return false
+}
+
+func (blockingVerifier) VerifyContext(ctx context.Context, msg []byte, signature *LinkableRingSignature) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestVerifyContext(t *testing.T) {
+	keys, ring := newTestRing(t, 5)
+	msg := []byte("verify context")
+	sig, err := SignRing(rand.Reader, SimpleParticipantRandInt, keys[0], ring, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, err := NewPreparedRing(ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifiers := map[string]func(ctx context.Context) error{
+		"plain": func(ctx context.Context) error {
+			return VerifyRingContext(ctx, ring, msg, sig)
+		},
+		"prepared": func(ctx context.Context) error {
+			return VerifyPreparedContext(ctx, pr, msg, sig)
+		},
+	}
+	for name, tt := range map[string]struct {
+		signer   RingSigner
+		verifier ContextVerifier
+	}{
+		"base":     {NewBaseLinkableSignerWithRing(keys[1], ring), NewBaseLinkableVerfierWithRing(ring)},
+		"variant1": {NewLinkableSignerVariant1WithRing(keys[1], ring), NewLinkableVerfierVariant1WithRing(ring)},
+		"variant2": {NewLinkableSignerVariant2WithRing(keys[1], ring), NewLinkableVerfierVariant2WithRing(ring)},
+		"scoped":   {NewScopedLinkableSignerWithRing(keys[1], ring, []byte("scope")), NewScopedLinkableVerfierWithRing(ring, []byte("scope"))},
+	} {
+		lsig, err := tt.signer.Sign(rand.Reader, SimpleParticipantRandInt, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verifiers[name] = func(ctx context.Context) error {
+			return tt.verifier.VerifyContext(ctx, msg, lsig)
+		}
+	}
+
+	for name, verify := range verifiers {
+		if err := verify(context.Background()); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		// cancellation is checked at every step
+		ctx := &countingContext{Context: context.Background(), calls: ring.Len() - 1}
+		if err := verify(ctx); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: cancelled at the last step: got %v", name, err)
+		}
+		expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		if err := verify(expired); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: expired deadline: got %v", name, err)
+		}
+		cancel()
+	}
+}
+
+func TestVerifierPolicy(t *testing.T) {
+	keys, ring := newTestRing(t, 4)
+	msg := []byte("policy")
+	sig, err := SignRing(rand.Reader, SimpleParticipantRandInt, keys[0], ring, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lsig, err := NewBaseLinkableSignerWithRing(keys[0], ring).Sign(rand.Reader, SimpleParticipantRandInt, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := NewBaseLinkableVerfierWithRing(ring)
+	pr, err := NewPreparedRing(ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	var unlimited VerifierPolicy
+	if err := unlimited.VerifyRing(ctx, ring, msg, sig); err != nil {
+		t.Error(err)
+	}
+	if err := unlimited.VerifyPrepared(ctx, pr, msg, sig); err != nil {
+		t.Error(err)
+	}
+	if err := unlimited.Verify(ctx, v, msg, lsig); err != nil {
+		t.Error(err)
+	}
+	if err := unlimited.Verify(ctx, &BaseLinkableVerfier{}, msg, lsig); !errors.Is(err, ErrRingTooSmall) {
+		t.Errorf("zero-value verifier: got %v, want ErrRingTooSmall", err)
+	}
+
+	exact := VerifierPolicy{MaxRingSize: 4, MaxMessageSize: len(msg), Timeout: time.Minute}
+	if err := exact.VerifyRing(ctx, ring, msg, sig); err != nil {
+		t.Error(err)
+	}
+	if err := exact.VerifyPrepared(ctx, pr, msg, sig); err != nil {
+		t.Error(err)
+	}
+	if err := exact.Verify(ctx, v, msg, lsig); err != nil {
+		t.Error(err)
+	}
+
+	small := VerifierPolicy{MaxRingSize: 3}
+	if err := small.VerifyRing(ctx, ring, msg, sig); !errors.Is(err, ErrRingTooLarge) {
+		t.Errorf("ring: got %v, want ErrRingTooLarge", err)
+	}
+	if err := small.VerifyPrepared(ctx, pr, msg, sig); !errors.Is(err, ErrRingTooLarge) {
+		t.Errorf("prepared ring: got %v, want ErrRingTooLarge", err)
+	}
+	if err := small.Verify(ctx, v, msg, lsig); !errors.Is(err, ErrRingTooLarge) {
+		t.Errorf("linkable ring: got %v, want ErrRingTooLarge", err)
+	}
+	// oversized signatures are rejected before they are looked at
+	if err := small.Verify(ctx, blockingVerifier{}, msg, &LinkableRingSignature{S: make([]*big.Int, 4)}); !errors.Is(err, ErrRingTooLarge) {
+		t.Errorf("signature: got %v, want ErrRingTooLarge", err)
+	}
+	if err := small.VerifyRing(ctx, &Ring{}, msg, &RingSignature{S: make([]*big.Int, 4)}); !errors.Is(err, ErrRingTooLarge) {
+		t.Errorf("plain signature: got %v, want ErrRingTooLarge", err)
+	}
+
+	short := VerifierPolicy{MaxMessageSize: len(msg) - 1}
+	if err := short.VerifyRing(ctx, ring, msg, sig); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("message: got %v, want ErrMessageTooLarge", err)
+	}
+	if err := short.Verify(ctx, v, msg, lsig); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("linkable message: got %v, want ErrMessageTooLarge", err)
+	}
+
+	// the timeout applies to verifiers of other packages too
+	timeout := VerifierPolicy{Timeout: time.Millisecond}
+	if err := timeout.Verify(ctx, blockingVerifier{}, msg, lsig); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("timeout: got %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestBatchVerifierContext(t *testing.T) {
+	keys, ring := newTestRing(t, 2)
+	msg := []byte("batch context")
+	sig, err := SignRing(rand.Reader, SimpleParticipantRandInt, keys[0], ring, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewBatchVerifier()
+	b.AddRing(ring, msg, sig)
+	b.AddLinkable(blockingVerifier{}, msg, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i, err := range b.VerifyContext(ctx) {
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("item %d: got %v, want context.Canceled", i, err)
+		}
+	}
+}
+
+func TestBatchVerifierPolicy(t *testing.T) {
+	keys, ring := newTestRing(t, 4)
+	smallKeys, small := newTestRing(t, 2)
+	msg := []byte("batch policy")
+	sig, err := SignRing(rand.Reader, SimpleParticipantRandInt, keys[0], ring, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lsig, err := NewBaseLinkableSignerWithRing(keys[1], ring).Sign(rand.Reader, SimpleParticipantRandInt, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	smallSig, err := SignRing(rand.Reader, SimpleParticipantRandInt, smallKeys[0], small, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewBatchVerifier(WithPolicy(VerifierPolicy{MaxRingSize: 3, MaxMessageSize: len(msg), Timeout: time.Millisecond}))
+	b.AddRing(ring, msg, sig)
+	b.AddLinkable(NewBaseLinkableVerfierWithRing(ring), msg, lsig)
+	// verifiers of other packages get signatures of at most MaxRingSize members
+	b.AddLinkable(blockingVerifier{}, msg, lsig)
+	b.AddRing(small, []byte("batch policy!"), smallSig)
+	// the timeout applies to each item
+	b.AddLinkable(blockingVerifier{}, msg, nil)
+	b.AddRing(small, msg, smallSig)
+	b.Add(ring.PublicKeys(), msg, sig)
+	want := []error{ErrRingTooLarge, ErrRingTooLarge, ErrRingTooLarge, ErrMessageTooLarge, context.DeadlineExceeded, nil, ErrRingTooLarge}
+	for i, err := range b.Verify() {
+		if !errors.Is(err, want[i]) {
+			t.Errorf("item %d: got %v, want %v", i, err, want[i])
+		}
+	}
+	// oversized rings are rejected before they are hashed
+	if b.items[6].ring.id != nil {
+		t.Error("the ID of an oversized ring was computed")
+	}
+}
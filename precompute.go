@@ -0,0 +1,98 @@
+package sm2rsign
+
+import (
+	"context"
+	"io"
+	"math/big"
+	"runtime"
+	"sync"
+)
+
+// parallelStepsThreshold is the number of members other than the signer
+// from which their products are computed across goroutines. A product costs
+// about as much as a scalar multiplication, which dwarfs the overhead of
+// handing it to another goroutine.
+const parallelStepsThreshold = 64
+
+// participantSteps are the scalars s_i of the ring members other than the
+// signer, and their products s_i·B with the base points B of the steps,
+// such as G and Hp. Neither depends on the challenges, so they are computed
+// before the signer walks the ring.
+type participantSteps struct {
+	s []*big.Int
+	// products[i][j] is s_i times the j-th base point
+	products [][]point
+}
+
+// drawParticipantSteps draws the scalars of the members other than the
+// signer at pai with others, in the order of the ring walk, so that the
+// signature only depends on the random stream and not on the scheduling.
+// For large rings the products are computed by a pool of goroutines while
+// the scalars are drawn. It returns ctx.Err() if ctx is done before all
+// scalars are drawn.
+func drawParticipantSteps(ctx context.Context, rand io.Reader, others *participants, pai int, bases ...multiplier) (*participantSteps, error) {
+	n := others.ctx.Ring.Len()
+	st := &participantSteps{s: make([]*big.Int, n), products: make([][]point, n)}
+	multiply := func(i int) {
+		st.products[i] = make([]point, len(bases))
+		for j, base := range bases {
+			base.mulAdd(&st.products[i][j], st.s[i])
+		}
+	}
+
+	var jobs chan int
+	var wg sync.WaitGroup
+	if n-1 >= parallelStepsThreshold {
+		jobs = make(chan int, n)
+		workers := runtime.GOMAXPROCS(0)
+		wg.Add(workers)
+		for w := 0; w < workers; w++ {
+			go func() {
+				defer wg.Done()
+				for i := range jobs {
+					// drain the queue if signing was cancelled
+					if ctx.Err() == nil {
+						multiply(i)
+					}
+				}
+			}()
+		}
+	}
+	err := func() error {
+		for k := 1; k < n; k++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			i := (pai + k) % n
+			s, err := others.draw(rand, i)
+			if err != nil {
+				return err
+			}
+			st.s[i] = s
+			if jobs == nil {
+				multiply(i)
+			} else {
+				jobs <- i
+			}
+		}
+		return nil
+	}()
+	if jobs != nil {
+		close(jobs)
+		wg.Wait()
+	}
+	if err == nil {
+		// the products are incomplete if ctx was done meanwhile
+		err = ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// product returns the product of s_i with the j-th base point. It can only
+// be used once, the steps accumulate into it.
+func (st *participantSteps) product(i, j int) *point {
+	return &st.products[i][j]
+}
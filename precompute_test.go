@@ -0,0 +1,105 @@
+package sm2rsign
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/big"
+	mathrand "math/rand/v2"
+	"testing"
+
+	"github.com/emmansun/gmsm/sm2"
+)
+
+// contextSigner signs with a context, like SignRingContext and the
+// SignContext methods of the linkable signers, and returns the encoded
+// signature.
+type contextSigner func(ctx context.Context, rand io.Reader, strategy ParticipantStrategy, msg []byte) ([]byte, error)
+
+func contextSigners(t *testing.T, priv *sm2.PrivateKey, ring *Ring) map[string]contextSigner {
+	t.Helper()
+	linkable := func(sign func(context.Context, io.Reader, ParticipantStrategy, []byte) (*LinkableRingSignature, error), v RingVerifier) contextSigner {
+		return func(ctx context.Context, rand io.Reader, strategy ParticipantStrategy, msg []byte) ([]byte, error) {
+			sig, err := sign(ctx, rand, strategy, msg)
+			if err != nil {
+				return nil, err
+			}
+			if !v.Verify(msg, sig) {
+				t.Error("signature does not verify")
+			}
+			return sig.MarshalASN1()
+		}
+	}
+	return map[string]contextSigner{
+		"plain": func(ctx context.Context, rand io.Reader, strategy ParticipantStrategy, msg []byte) ([]byte, error) {
+			sig, err := SignRingContext(ctx, rand, strategy, priv, ring, msg)
+			if err != nil {
+				return nil, err
+			}
+			if !VerifyRing(ring, msg, sig) {
+				t.Error("signature does not verify")
+			}
+			return sig.MarshalASN1()
+		},
+		"base":     linkable(NewBaseLinkableSignerWithRing(priv, ring).SignContext, NewBaseLinkableVerfierWithRing(ring)),
+		"variant1": linkable(NewLinkableSignerVariant1WithRing(priv, ring).SignContext, NewLinkableVerfierVariant1WithRing(ring)),
+		"variant2": linkable(NewLinkableSignerVariant2WithRing(priv, ring).SignContext, NewLinkableVerfierVariant2WithRing(ring)),
+		"scoped":   linkable(NewScopedLinkableSignerWithRing(priv, ring, []byte("scope")).SignContext, NewScopedLinkableVerfierWithRing(ring, []byte("scope"))),
+	}
+}
+
+func TestSignContextLargeRing(t *testing.T) {
+	keys, ring := newTestRing(t, parallelStepsThreshold+8)
+	msg := []byte("large ring")
+	for name, sign := range contextSigners(t, keys[parallelStepsThreshold/2], ring) {
+		// the products are computed concurrently, but the signature only
+		// depends on the random stream
+		var sigs [2][]byte
+		for i := range sigs {
+			var err error
+			sigs[i], err = sign(context.Background(), mathrand.NewChaCha8([32]byte{1}), ParticipantRandInt(SM2ParticipantRandInt), msg)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+		if !bytes.Equal(sigs[0], sigs[1]) {
+			t.Errorf("%s: signatures differ for the same random stream", name)
+		}
+	}
+}
+
+// cancellingStrategy cancels signing after a number of draws.
+type cancellingStrategy struct {
+	cancel context.CancelFunc
+	draws  int
+}
+
+func (s *cancellingStrategy) RandInt(rand io.Reader, ctx *ParticipantContext) (*big.Int, error) {
+	if s.draws--; s.draws == 0 {
+		s.cancel()
+	}
+	return SimpleParticipantRandInt(rand, ctx.PublicKey, ctx.Message)
+}
+
+func TestSignContextCancel(t *testing.T) {
+	for _, n := range []int{4, parallelStepsThreshold + 8} {
+		keys, ring := newTestRing(t, n)
+		for name, sign := range contextSigners(t, keys[0], ring) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			if _, err := sign(ctx, mathrand.NewChaCha8([32]byte{}), ParticipantRandInt(SimpleParticipantRandInt), nil); !errors.Is(err, context.Canceled) {
+				t.Errorf("%s/%d: cancelled before signing: got %v", name, n, err)
+			}
+
+			ctx, cancel = context.WithCancel(context.Background())
+			strategy := &cancellingStrategy{cancel: cancel, draws: 2}
+			if _, err := sign(ctx, mathrand.NewChaCha8([32]byte{}), strategy, nil); !errors.Is(err, context.Canceled) {
+				t.Errorf("%s/%d: cancelled while signing: got %v", name, n, err)
+			}
+			if strategy.draws != 0 {
+				t.Errorf("%s/%d: %d more draws after cancellation", name, n, -strategy.draws)
+			}
+		}
+	}
+}
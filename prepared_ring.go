@@ -0,0 +1,177 @@
+package sm2rsign
+
+import (
+	"context"
+	"math/big"
+	"sync"
+)
+
+// fixedTableThreshold is the number of multiplications of a point, such as
+// the key image or the base point Hp of a linkable signature which are
+// multiplied once per ring member, from which a table of the point is
+// precomputed. Computing the table costs about as much as 17 scalar
+// multiplications.
+const fixedTableThreshold = 24
+
+// multiplier adds multiples of a fixed point to an accumulator.
+type multiplier interface {
+	// mulAdd sets acc = acc + k·P for k in [0, 2^256).
+	mulAdd(acc *point, k *big.Int)
+	// addTo sets acc = acc + P.
+	addTo(acc *point)
+}
+
+// affineMultiplier is a multiplier without precomputation, a nil point
+// stands for the point at infinity. It uses point.scalarMult, which is
+// faster than a multiplication with the arithmetic of this package unless a
+// table is available.
+type affineMultiplier struct {
+	p *affinePoint
+}
+
+// newAffineMultiplier returns an affineMultiplier of (x, y), where (x, y)
+// is a point on the curve or (0, 0) for the point at infinity.
+func newAffineMultiplier(x, y *big.Int) affineMultiplier {
+	p, _ := newAffinePoint(x, y)
+	return affineMultiplier{p}
+}
+
+func (m affineMultiplier) mulAdd(acc *point, k *big.Int) {
+	if m.p == nil {
+		return
+	}
+	var t point
+	acc.add(acc, t.scalarMult(m.p, k))
+}
+
+func (m affineMultiplier) addTo(acc *point) {
+	if m.p != nil {
+		acc.addAffine(acc, m.p)
+	}
+}
+
+// fixedMultiplier returns a multiplier of (x, y), which will be multiplied
+// n times.
+func fixedMultiplier(x, y *big.Int, n int) multiplier {
+	if n < fixedTableThreshold {
+		return newAffineMultiplier(x, y)
+	}
+	if x.Sign() == 0 && y.Sign() == 0 {
+		// the nil table multiplies the point at infinity
+		return (*combTable)(nil)
+	}
+	p, _ := newAffinePoint(x, y)
+	return newCombTable(p, memberTableWidth)
+}
+
+// mulAddSum sets acc = acc + k·(A + B). Unless both A and B have tables,
+// A + B is computed first, which saves a scalar multiplication.
+func mulAddSum(acc *point, a, b multiplier, k *big.Int) {
+	_, aAffine := a.(affineMultiplier)
+	_, bAffine := b.(affineMultiplier)
+	if !aAffine && !bAffine {
+		a.mulAdd(acc, k)
+		b.mulAdd(acc, k)
+		return
+	}
+	var sum point
+	a.addTo(&sum)
+	b.addTo(&sum)
+	newAffineMultiplier(sum.affine()).mulAdd(acc, k)
+}
+
+// PreparedRing is a Ring with precomputed tables of its members, which
+// make verification much faster at the cost of about 53 KB of memory per
+// member. It is intended for verifiers which check many signatures over the
+// same ring, see VerifyPrepared and the ...WithPreparedRing verifier
+// constructors. Tables of the linkability base points are computed on first
+// use. A PreparedRing is safe for concurrent use.
+//
+// The tables are only used with public data, they do not need the constant
+// time arithmetic of the signer.
+type PreparedRing struct {
+	ring    *Ring
+	members []*combTable
+
+	mu     sync.Mutex
+	points map[string]*combTable
+}
+
+// NewPreparedRing precomputes the tables of the members of ring, which
+// takes about 1 ms per member. It returns an error wrapping
+// ErrRingTooSmall, ErrNonSM2PublicKey or ErrInvalidPublicKey if ring is not
+// valid.
+func NewPreparedRing(ring *Ring) (*PreparedRing, error) {
+	if ring == nil {
+		return nil, ErrRingTooSmall
+	}
+	if err := checkRing(ring.keys); err != nil {
+		return nil, err
+	}
+	pr := &PreparedRing{
+		ring:    ring,
+		members: make([]*combTable, len(ring.keys)),
+		points:  make(map[string]*combTable),
+	}
+	for i, pub := range ring.keys {
+		p, _ := newAffinePoint(pub.X, pub.Y)
+		pr.members[i] = newCombTable(p, memberTableWidth)
+	}
+	return pr, nil
+}
+
+// Ring returns the ring the tables were computed for.
+func (pr *PreparedRing) Ring() *Ring {
+	return pr.ring
+}
+
+// member returns a multiplier of the i-th member of ring, using the tables
+// of pr if it is not nil. pr must have been prepared for ring.
+func (pr *PreparedRing) member(ring *Ring, i int) multiplier {
+	if pr == nil {
+		return newAffineMultiplier(ring.keys[i].X, ring.keys[i].Y)
+	}
+	return pr.members[i]
+}
+
+// point returns a multiplier of (x, y), such as a linkability base point of
+// the ring, using a table cached by pr if it is not nil. Otherwise, the
+// point will be multiplied n times, see fixedMultiplier.
+func (pr *PreparedRing) point(x, y *big.Int, n int) multiplier {
+	if pr == nil {
+		return fixedMultiplier(x, y, n)
+	}
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return (*combTable)(nil)
+	}
+	key := string(appendUncompressed(nil, x, y))
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	table, ok := pr.points[key]
+	if !ok {
+		p, _ := newAffinePoint(x, y)
+		table = newCombTable(p, memberTableWidth)
+		pr.points[key] = table
+	}
+	return table
+}
+
+// VerifyPrepared is like VerifyRing, but uses the tables of a PreparedRing.
+func VerifyPrepared(ring *PreparedRing, msg []byte, signature *RingSignature) bool {
+	return VerifyPreparedWithError(ring, msg, signature) == nil
+}
+
+// VerifyPreparedWithError is like VerifyRingWithError, but uses the tables
+// of a PreparedRing.
+func VerifyPreparedWithError(ring *PreparedRing, msg []byte, signature *RingSignature) error {
+	return VerifyPreparedContext(context.Background(), ring, msg, signature)
+}
+
+// VerifyPreparedContext is like VerifyRingContext, but uses the tables of a
+// PreparedRing.
+func VerifyPreparedContext(ctx context.Context, ring *PreparedRing, msg []byte, signature *RingSignature) error {
+	if ring == nil {
+		return ErrRingTooSmall
+	}
+	return verifyRing(ctx, ring.ring, ring, msg, signature)
+}
@@ -0,0 +1,158 @@
+package sm2rsign
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/emmansun/gmsm/sm2"
+)
+
+func newTestRing(t testing.TB, n int) ([]*sm2.PrivateKey, *Ring) {
+	t.Helper()
+	keys := make([]*sm2.PrivateKey, n)
+	pubs := make([]*ecdsa.PublicKey, n)
+	for i := range keys {
+		keys[i], _ = sm2.GenerateKey(rand.Reader)
+		pubs[i] = &keys[i].PublicKey
+	}
+	ring, err := NewRing(pubs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys, ring
+}
+
+func TestPreparedRing(t *testing.T) {
+	keys, ring := newTestRing(t, 3)
+	pr, err := NewPreparedRing(ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pr.Ring() != ring {
+		t.Errorf("unexpected ring")
+	}
+	msg := []byte("hello world")
+	sig, err := SignRing(rand.Reader, SimpleParticipantRandInt, keys[1], ring, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyPreparedWithError(pr, msg, sig); err != nil {
+		t.Fatal(err)
+	}
+	if VerifyPrepared(pr, []byte("hello world!"), sig) {
+		t.Errorf("verified a signature of another message")
+	}
+	sig.S[0] = new(big.Int).Add(sig.S[0], big.NewInt(1))
+	if err := VerifyPreparedWithError(pr, msg, sig); !errors.Is(err, ErrRingEquation) {
+		t.Errorf("got %v, want %v", err, ErrRingEquation)
+	}
+
+	if _, err := NewPreparedRing(nil); !errors.Is(err, ErrRingTooSmall) {
+		t.Errorf("got %v, want %v", err, ErrRingTooSmall)
+	}
+	if err := VerifyPreparedWithError(nil, msg, sig); !errors.Is(err, ErrRingTooSmall) {
+		t.Errorf("got %v, want %v", err, ErrRingTooSmall)
+	}
+}
+
+// errorVerifier is implemented by all linkable verifiers of this package.
+type errorVerifier interface {
+	RingVerifier
+	VerifyWithError(msg []byte, signature *LinkableRingSignature) error
+}
+
+func TestPreparedRingLinkable(t *testing.T) {
+	msg := []byte("hello world")
+	scope := []byte("epoch-42")
+	// the larger ring uses a table for the key image
+	for _, n := range []int{3, fixedTableThreshold} {
+		keys, ring := newTestRing(t, n)
+		pr, err := NewPreparedRing(ring)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, opts := range [][]LinkableOption{nil, {WithHashToCurve()}} {
+			tests := []struct {
+				signer            RingSigner
+				verifier, prepare errorVerifier
+			}{
+				{NewBaseLinkableSignerWithRing(keys[1], ring, opts...), NewBaseLinkableVerfierWithRing(ring, opts...), NewBaseLinkableVerfierWithPreparedRing(pr, opts...)},
+				{NewLinkableSignerVariant1WithRing(keys[1], ring, opts...), NewLinkableVerfierVariant1WithRing(ring, opts...), NewLinkableVerfierVariant1WithPreparedRing(pr, opts...)},
+				{NewLinkableSignerVariant2WithRing(keys[1], ring, opts...), NewLinkableVerfierVariant2WithRing(ring, opts...), NewLinkableVerfierVariant2WithPreparedRing(pr, opts...)},
+				{NewScopedLinkableSignerWithRing(keys[1], ring, scope, opts...), NewScopedLinkableVerfierWithRing(ring, scope, opts...), NewScopedLinkableVerfierWithPreparedRing(pr, scope, opts...)},
+			}
+			for _, tt := range tests {
+				sig, err := tt.signer.Sign(rand.Reader, SimpleParticipantRandInt, msg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := tt.prepare.VerifyWithError(msg, sig); err != nil {
+					t.Errorf("%v, %d members: %v", sig.Scheme, n, err)
+				}
+				// a second verification uses the cached base point tables
+				if !tt.prepare.Verify(msg, sig) {
+					t.Errorf("%v, %d members: failed to verify again", sig.Scheme, n)
+				}
+				sig.S[n-1] = new(big.Int).Add(sig.S[n-1], big.NewInt(1))
+				want := tt.verifier.VerifyWithError(msg, sig)
+				if err := tt.prepare.VerifyWithError(msg, sig); !errors.Is(err, ErrRingEquation) || !errors.Is(want, ErrRingEquation) {
+					t.Errorf("%v, %d members: got %v and %v, want %v", sig.Scheme, n, err, want, ErrRingEquation)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkVerifyPrepared(b *testing.B) {
+	msg := []byte("hello world")
+	for _, n := range []int{2, 8, 32} {
+		keys, ring := newTestRing(b, n)
+		pr, err := NewPreparedRing(ring)
+		if err != nil {
+			b.Fatal(err)
+		}
+		sig, err := SignRing(rand.Reader, SimpleParticipantRandInt, keys[0], ring, msg)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(fmt.Sprintf("Verify/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if !VerifyRing(ring, msg, sig) {
+					b.Fatal("verification failed")
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("VerifyPrepared/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if !VerifyPrepared(pr, msg, sig) {
+					b.Fatal("verification failed")
+				}
+			}
+		})
+
+		lsig, err := NewBaseLinkableSignerWithRing(keys[0], ring).Sign(rand.Reader, SimpleParticipantRandInt, msg)
+		if err != nil {
+			b.Fatal(err)
+		}
+		verifiers := []struct {
+			name string
+			v    *BaseLinkableVerfier
+		}{
+			{"BaseLinkable", NewBaseLinkableVerfierWithRing(ring)},
+			{"BaseLinkablePrepared", NewBaseLinkableVerfierWithPreparedRing(pr)},
+		}
+		for _, tt := range verifiers {
+			b.Run(fmt.Sprintf("%s/%d", tt.name, n), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if !tt.v.Verify(msg, lsig) {
+						b.Fatal("verification failed")
+					}
+				}
+			})
+		}
+	}
+}
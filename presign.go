@@ -0,0 +1,212 @@
+package sm2rsign
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/big"
+	"sync"
+
+	"github.com/emmansun/gmsm/sm2"
+)
+
+// Presignature failures.
+var (
+	ErrPresignatureUsed     = errors.New("sm2rsign: presignature has already been used")
+	ErrPresignatureMismatch = errors.New("sm2rsign: presignature was made by another signer")
+	ErrPresignRandomness    = errors.New("sm2rsign: presignatures need randomness which does not depend on the message")
+)
+
+// Presignature is the part of a ring signature which does not depend on the
+// message, computed ahead of time by PresignRing or the Presign method of a
+// linkable signer: the signer's nonce k and its multiples, the key image,
+// the scalars s_i of the other members and their multiples of the base
+// points. Only the chaining of the challenges, which costs one or two
+// scalar multiplications per member, and the signer's response are left for
+// the message.
+//
+// A Presignature holds the secret nonce k, which would reveal the private
+// key if two messages were signed with it. It can only be used once, even
+// if signing fails, and must not be copied or stored.
+type Presignature struct {
+	mu   sync.Mutex
+	used bool
+	// owner is the linkable signer which made the presignature, nil for a
+	// plain ring signature
+	owner any
+	priv  *sm2.PrivateKey
+	ring  *Ring
+	pai   int
+	k     *big.Int
+	// kG, and k·Hp or k·R in the linkable schemes, see the signers
+	kx, ky, krx, kry *big.Int
+	// the key image of a linkable signature
+	qx, qy *big.Int
+	steps  *linkableSteps
+	others *participantSteps
+}
+
+// checkPresignRandomness refuses random sources which derive the nonce from
+// the message, such as Deterministic, or the scalars of the other members,
+// such as SeededParticipants, since the message is not known yet and all
+// the presignatures would share them. The strategy is only recognized by
+// its type, see PresignRing.
+func checkPresignRandomness(rand io.Reader, strategy ParticipantStrategy) error {
+	if src, ok := rand.(nonceSource); ok && src.entropy == nil {
+		return ErrPresignRandomness
+	}
+	if _, ok := strategy.(seededParticipants); ok {
+		return ErrPresignRandomness
+	}
+	return nil
+}
+
+// use marks pre as used by owner. It returns ErrPresignatureUsed if pre was
+// used before and ErrPresignatureMismatch if it was made by another signer.
+func (pre *Presignature) use(owner any) error {
+	if pre == nil {
+		return ErrPresignatureMismatch
+	}
+	pre.mu.Lock()
+	defer pre.mu.Unlock()
+	if pre.used {
+		return ErrPresignatureUsed
+	}
+	if pre.owner != owner {
+		return ErrPresignatureMismatch
+	}
+	pre.used = true
+	return nil
+}
+
+// Discard erases the nonce of an unused presignature, which can not be used
+// afterwards. Used presignatures are erased when signing ends.
+func (pre *Presignature) Discard() {
+	pre.mu.Lock()
+	defer pre.mu.Unlock()
+	if !pre.used {
+		pre.used = true
+		zeroBig(pre.k)
+	}
+}
+
+// chain walks the ring from the step after the signer, where c is the
+// challenge of that step and next returns the challenge after step i given
+// c + s_i. It returns the challenge c_0 of the signature and the challenge
+// of the signer's step, or ctx.Err() if ctx is done before.
+func (pre *Presignature) chain(ctx context.Context, c *big.Int, next func(i int, c *big.Int) *big.Int) (c0, cPai *big.Int, err error) {
+	N := sm2.P256().Params().N
+	n := pre.ring.Len()
+	for k := 1; k < n; k++ {
+		i := (pre.pai + k) % n
+		if i == 0 {
+			c0 = new(big.Int).Set(c)
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		c.Add(pre.others.s[i], c)
+		c.Mod(c, N)
+		c = next(i, c)
+	}
+	if pre.pai == 0 {
+		c0 = new(big.Int).Set(c)
+	}
+	return c0, c, nil
+}
+
+// PresignRing computes the part of a ring signature by priv over ring
+// which does not depend on the message, see Presignature. Signing with
+// SignRingPresigned then gives the same signature as SignRingContext with
+// the same random stream and a strategy which ignores the message.
+//
+// The message is not known yet, so strategy is called with a nil message.
+// Strategies which hash the message, such as SM2ParticipantRandInt and
+// SM2UIDParticipants, hash an empty message instead: s_i stays random, but
+// does not depend on the message. PresignRing returns ErrPresignRandomness
+// for Deterministic and SeededParticipants, whose randomness is derived
+// from the message and would be shared by all presignatures.
+//
+// Only these two are recognized, by their type: a strategy which wraps
+// SeededParticipants, or any other strategy deriving s_i from the message
+// and not from the random source, is not detected. Its presignatures
+// would share their s_i, so it must not be used for presigning.
+func PresignRing(ctx context.Context, rand io.Reader, strategy ParticipantStrategy, priv *sm2.PrivateKey, ring *Ring) (*Presignature, error) {
+	if ring == nil {
+		return nil, ErrRingTooSmall
+	}
+	if err := checkPresignRandomness(rand, strategy); err != nil {
+		return nil, err
+	}
+	return presignRing(ctx, rand, strategy, priv, ring, nil)
+}
+
+// SignRingPresigned signs msg with a presignature made by PresignRing. It
+// returns ErrPresignatureUsed if pre was used before.
+func SignRingPresigned(ctx context.Context, pre *Presignature, msg []byte) (*RingSignature, error) {
+	if err := pre.use(nil); err != nil {
+		return nil, err
+	}
+	return pre.signRing(ctx, msg)
+}
+
+// presignRing is PresignRing, where msg is the message if it is known,
+// which the random source and the strategy may depend on.
+func presignRing(ctx context.Context, rand io.Reader, strategy ParticipantStrategy, priv *sm2.PrivateKey, ring *Ring, msg []byte) (_ *Presignature, err error) {
+	pai, err := getPai(priv, ring.keys)
+	if err != nil {
+		return nil, err
+	}
+	// plain ring signatures have no scheme and no base point
+	rand, err = signingReader(rand, priv, ring, 0, nil, msg)
+	if err != nil {
+		return nil, err
+	}
+	others := newParticipants(strategy, ring, 0, msg)
+	pre := &Presignature{priv: priv, ring: ring, pai: pai}
+	defer pre.discardOnError(&err)
+
+	// Step 1
+	if pre.k, err = randFieldElement(priv, rand); err != nil {
+		return nil, err
+	}
+	if pre.kx, pre.ky, err = secretMult(nil, pre.k); err != nil {
+		return nil, err
+	}
+	// the scalars of the other members and their multiples of G
+	if pre.others, err = drawParticipantSteps(ctx, rand, others, pai, baseTable()); err != nil {
+		return nil, err
+	}
+	return pre, nil
+}
+
+// discardOnError erases the nonce of pre if *err is not nil, when the
+// presignature could not be completed.
+func (pre *Presignature) discardOnError(err *error) {
+	if *err != nil {
+		zeroBig(pre.k)
+	}
+}
+
+// signRing is the online phase of a plain ring signature.
+func (pre *Presignature) signRing(ctx context.Context, msg []byte) (*RingSignature, error) {
+	defer zeroBig(pre.k)
+	ring := pre.ring
+	ch := newChallengeHasher(ring.hashPrefix(), msg)
+	c := ch.hash(pre.kx, pre.ky)
+
+	// Step 2
+	c0, c, err := pre.chain(ctx, c, func(i int, c *big.Int) *big.Int {
+		return ch.hash(ringStepFrom(pre.others.product(i, 0), nil, ring, i, c))
+	})
+	if err != nil {
+		return nil, err
+	}
+	sig := &RingSignature{C: c0, S: pre.others.s, RingID: ring.ID()}
+	// Step 3: this step is same with SM2 signature scheme
+	sig.S[pre.pai], err = signerResponse(pre.k, c, pre.priv.D)
+	if err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
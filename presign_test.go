@@ -0,0 +1,212 @@
+package sm2rsign
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"math/big"
+	mathrand "math/rand/v2"
+	"sync"
+	"testing"
+
+	"github.com/emmansun/gmsm/sm2"
+)
+
+// presigner makes presignatures and signs with them, returning the encoded
+// signatures, which have been verified.
+type presigner struct {
+	presign func(ctx context.Context, rand io.Reader, strategy ParticipantStrategy) (*Presignature, error)
+	sign    func(ctx context.Context, pre *Presignature, msg []byte) ([]byte, error)
+}
+
+func presigners(t *testing.T, priv *sm2.PrivateKey, ring *Ring) map[string]presigner {
+	t.Helper()
+	type linkableSigner interface {
+		Presign(ctx context.Context, rand io.Reader, strategy ParticipantStrategy) (*Presignature, error)
+		SignPresigned(ctx context.Context, pre *Presignature, msg []byte) (*LinkableRingSignature, error)
+	}
+	linkable := func(s linkableSigner, v RingVerifier) presigner {
+		return presigner{s.Presign, func(ctx context.Context, pre *Presignature, msg []byte) ([]byte, error) {
+			sig, err := s.SignPresigned(ctx, pre, msg)
+			if err != nil {
+				return nil, err
+			}
+			if !v.Verify(msg, sig) {
+				t.Error("signature does not verify")
+			}
+			return sig.MarshalASN1()
+		}}
+	}
+	return map[string]presigner{
+		"plain": {
+			func(ctx context.Context, rand io.Reader, strategy ParticipantStrategy) (*Presignature, error) {
+				return PresignRing(ctx, rand, strategy, priv, ring)
+			},
+			func(ctx context.Context, pre *Presignature, msg []byte) ([]byte, error) {
+				sig, err := SignRingPresigned(ctx, pre, msg)
+				if err != nil {
+					return nil, err
+				}
+				if !VerifyRing(ring, msg, sig) {
+					t.Error("signature does not verify")
+				}
+				return sig.MarshalASN1()
+			},
+		},
+		"base":     linkable(NewBaseLinkableSignerWithRing(priv, ring), NewBaseLinkableVerfierWithRing(ring)),
+		"variant1": linkable(NewLinkableSignerVariant1WithRing(priv, ring), NewLinkableVerfierVariant1WithRing(ring)),
+		"variant2": linkable(NewLinkableSignerVariant2WithRing(priv, ring), NewLinkableVerfierVariant2WithRing(ring)),
+		"scoped":   linkable(NewScopedLinkableSignerWithRing(priv, ring, []byte("scope")), NewScopedLinkableVerfierWithRing(ring, []byte("scope"))),
+	}
+}
+
+func TestPresignature(t *testing.T) {
+	ctx := context.Background()
+	keys, ring := newTestRing(t, 4)
+	msg := []byte("online")
+	strategy := ParticipantRandInt(SimpleParticipantRandInt)
+	direct := contextSigners(t, keys[1], ring)
+	for name, p := range presigners(t, keys[1], ring) {
+		pre, err := p.presign(ctx, mathrand.NewChaCha8([32]byte{7}), strategy)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		sig, err := p.sign(ctx, pre, msg)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		// the same random stream gives the same signature in one go
+		want, err := direct[name](ctx, mathrand.NewChaCha8([32]byte{7}), strategy, msg)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(sig, want) {
+			t.Errorf("%s: presigned signature differs from SignContext", name)
+		}
+		if _, err := p.sign(ctx, pre, []byte("another message")); !errors.Is(err, ErrPresignatureUsed) {
+			t.Errorf("%s: reuse: got %v, want ErrPresignatureUsed", name, err)
+		}
+		if pre.k.Sign() != 0 {
+			t.Errorf("%s: nonce not erased after signing", name)
+		}
+
+		// random sources which depend on the message are refused
+		if _, err := p.presign(ctx, Deterministic(), strategy); !errors.Is(err, ErrPresignRandomness) {
+			t.Errorf("%s: Deterministic: got %v, want ErrPresignRandomness", name, err)
+		}
+		if _, err := p.presign(ctx, rand.Reader, SeededParticipants([]byte("seed"))); !errors.Is(err, ErrPresignRandomness) {
+			t.Errorf("%s: SeededParticipants: got %v, want ErrPresignRandomness", name, err)
+		}
+		if pre, err = p.presign(ctx, Hedged(rand.Reader), strategy); err != nil {
+			t.Errorf("%s: Hedged: %v", name, err)
+		} else if _, err := p.sign(ctx, pre, msg); err != nil {
+			t.Errorf("%s: Hedged: %v", name, err)
+		}
+
+		pre, err = p.presign(ctx, rand.Reader, strategy)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		pre.Discard()
+		if pre.k.Sign() != 0 {
+			t.Errorf("%s: nonce not erased by Discard", name)
+		}
+		if _, err := p.sign(ctx, pre, msg); !errors.Is(err, ErrPresignatureUsed) {
+			t.Errorf("%s: discarded: got %v, want ErrPresignatureUsed", name, err)
+		}
+	}
+}
+
+// withoutMessage calls a strategy with a nil message, as presigning does.
+type withoutMessage struct {
+	ParticipantStrategy
+}
+
+func (w withoutMessage) RandInt(rand io.Reader, ctx *ParticipantContext) (*big.Int, error) {
+	c := *ctx
+	c.Message = nil
+	return w.ParticipantStrategy.RandInt(rand, &c)
+}
+
+func TestPresignatureMessageStrategies(t *testing.T) {
+	ctx := context.Background()
+	keys, ring := newTestRing(t, 3)
+	msg := []byte("online")
+	direct := contextSigners(t, keys[0], ring)
+	// strategies which hash the message hash a nil message instead
+	for _, strategy := range []ParticipantStrategy{ParticipantRandInt(SM2ParticipantRandInt), SM2UIDParticipants(nil)} {
+		for name, p := range presigners(t, keys[0], ring) {
+			pre, err := p.presign(ctx, mathrand.NewChaCha8([32]byte{3}), strategy)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			sig, err := p.sign(ctx, pre, msg)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			want, err := direct[name](ctx, mathrand.NewChaCha8([32]byte{3}), withoutMessage{strategy}, msg)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if !bytes.Equal(sig, want) {
+				t.Errorf("%s, %T: presigned signature differs from signing with a nil message", name, strategy)
+			}
+		}
+	}
+}
+
+func TestPresignatureMismatch(t *testing.T) {
+	ctx := context.Background()
+	keys, ring := newTestRing(t, 3)
+	ps := presigners(t, keys[0], ring)
+	// another signer of the same scheme, key and ring
+	other := presigners(t, keys[0], ring)
+	for name, p := range ps {
+		for otherName, o := range other {
+			pre, err := p.presign(ctx, rand.Reader, ParticipantRandInt(SimpleParticipantRandInt))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := o.sign(ctx, pre, nil); !errors.Is(err, ErrPresignatureMismatch) && !(name == "plain" && otherName == "plain") {
+				t.Errorf("%s presignature used by %s: got %v, want ErrPresignatureMismatch", name, otherName, err)
+			}
+		}
+		if _, err := p.sign(ctx, nil, nil); !errors.Is(err, ErrPresignatureMismatch) {
+			t.Errorf("%s: nil presignature: got %v", name, err)
+		}
+	}
+}
+
+func TestPresignatureConcurrentUse(t *testing.T) {
+	ctx := context.Background()
+	keys, ring := newTestRing(t, 3)
+	signer := NewBaseLinkableSignerWithRing(keys[2], ring)
+	pre, err := signer.Presign(ctx, rand.Reader, ParticipantRandInt(SimpleParticipantRandInt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = signer.SignPresigned(ctx, pre, []byte{byte(i)})
+		}()
+	}
+	wg.Wait()
+	signed := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			signed++
+		case !errors.Is(err, ErrPresignatureUsed):
+			t.Error(err)
+		}
+	}
+	if signed != 1 {
+		t.Errorf("presignature used %d times", signed)
+	}
+}
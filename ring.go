@@ -0,0 +1,374 @@
+package sm2rsign
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding"
+	"fmt"
+	"hash"
+	"math/big"
+	"sort"
+	"sync"
+
+	"github.com/emmansun/gmsm/sm2"
+	"github.com/emmansun/gmsm/sm3"
+)
+
+// ringIDPrefix separates ring IDs from other SM3 hashes of public keys.
+const ringIDPrefix = "SM2RSIGN-RING-ID-V01"
+
+// Ring is an immutable list of distinct SM2 public keys, optionally with
+// their user IDs, see RingMember. The order of the members is part of the
+// signature, so signers and verifiers should build their rings the same
+// way, e.g. with WithCanonicalOrder.
+type Ring struct {
+	keys []*ecdsa.PublicKey
+	// uids are the user IDs of the members, nil if all use the default one
+	uids [][]byte
+	// zas are the ZA values of the members if they are bound into the
+	// challenge hashes and the ring ID, see WithZABinding
+	zas [][]byte
+	// proven is set if the members proved possession of their keys, see
+	// WithProofOfPossession
+	proven bool
+
+	// data derived from the members, computed on first use or carried over
+	// from the previous version by RingManager
+	mu         sync.Mutex
+	id         []byte
+	prefix     []byte // marshaled SM3 state, see hashPrefix
+	sumX, sumY *big.Int
+	h2cX, h2cY *big.Int
+}
+
+// RingOption configures NewRing and NewRingWithMembers.
+type RingOption func(*ringOptions)
+
+type ringOptions struct {
+	canonical bool
+	bindZA    bool
+	proofs    bool
+}
+
+// WithCanonicalOrder sorts the ring members by their compressed SEC 1
+// encoding, so that the same set of keys always yields the same ring
+// regardless of the order they were supplied in.
+func WithCanonicalOrder() RingOption {
+	return func(o *ringOptions) {
+		o.canonical = true
+	}
+}
+
+// WithZABinding binds the ZA value of every member, which is derived from
+// its public key and its user ID, see RingMember, into the challenge hashes
+// and the ring ID. Signatures over such a ring only verify with the same
+// user IDs, and are not compatible with rings built without this option.
+func WithZABinding() RingOption {
+	return func(o *ringOptions) {
+		o.bindZA = true
+	}
+}
+
+// WithProofOfPossession requires every member to supply a proof of
+// possession of their private key for the ring, see RingMember.Proof and
+// ProvePossession. The ring is only built if all the proofs are valid, and
+// is then reported as Proven. The option does not change the ring ID.
+func WithProofOfPossession() RingOption {
+	return func(o *ringOptions) {
+		o.proofs = true
+	}
+}
+
+// RingMember is a ring member together with its SM2 user ID, the
+// distinguishing ID which the SM2 identity conventions hash into the ZA
+// value of the member, as carried by the certificates of a PKI.
+type RingMember struct {
+	PublicKey *ecdsa.PublicKey
+	// UID is the user ID of the member. If it is empty, the default user ID
+	// "1234567812345678" is used.
+	UID []byte
+	// Proof is the proof of possession of the private key of the member,
+	// see ProvePossession. It is only used with WithProofOfPossession.
+	Proof []byte
+}
+
+// maxUIDLen is the maximum length of an SM2 user ID, whose bit length is
+// encoded in 16 bits.
+const maxUIDLen = 0x1fff
+
+// NewRing creates a ring from copies of pubs. It returns an error wrapping
+// ErrRingTooSmall, ErrNonSM2PublicKey, ErrInvalidPublicKey or
+// ErrDuplicatePublicKey if pubs is not a valid ring.
+func NewRing(pubs []*ecdsa.PublicKey, opts ...RingOption) (*Ring, error) {
+	members := make([]RingMember, len(pubs))
+	for i, pub := range pubs {
+		members[i].PublicKey = pub
+	}
+	return NewRingWithMembers(members, opts...)
+}
+
+// NewRingWithMembers is like NewRing, but every member has its own user ID.
+// The user IDs are used by SM2UIDParticipants and, with WithZABinding, in
+// the challenge hashes. It also returns an error wrapping ErrInvalidUID if a
+// user ID is longer than 8191 bytes, and with WithProofOfPossession, an
+// error wrapping ErrInvalidProof if the proof of a member is not valid.
+func NewRingWithMembers(members []RingMember, opts ...RingOption) (*Ring, error) {
+	var o ringOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	pubs := make([]*ecdsa.PublicKey, len(members))
+	var uids [][]byte
+	for i, m := range members {
+		pubs[i] = m.PublicKey
+		if len(m.UID) > maxUIDLen {
+			return nil, fmt.Errorf("%w: member %d", ErrInvalidUID, i)
+		}
+		if len(m.UID) != 0 {
+			if uids == nil {
+				uids = make([][]byte, len(members))
+			}
+			uids[i] = append([]byte{}, m.UID...)
+		}
+	}
+	if err := checkRing(pubs); err != nil {
+		return nil, err
+	}
+	r := newRing(pubs)
+	r.uids = uids
+	// order maps the ring positions to the positions in members
+	order := make([]int, len(r.keys))
+	for i := range order {
+		order[i] = i
+	}
+	if o.canonical {
+		encodings := make([][]byte, len(r.keys))
+		for i, pub := range r.keys {
+			encodings[i] = elliptic.MarshalCompressed(pub.Curve, pub.X, pub.Y)
+		}
+		sort.Slice(order, func(i, j int) bool {
+			return bytes.Compare(encodings[order[i]], encodings[order[j]]) < 0
+		})
+		keys := make([]*ecdsa.PublicKey, len(order))
+		for i, j := range order {
+			keys[i] = r.keys[j]
+		}
+		r.keys = keys
+		if uids != nil {
+			r.uids = make([][]byte, len(order))
+			for i, j := range order {
+				r.uids[i] = uids[j]
+			}
+		}
+	}
+	if o.bindZA {
+		r.zas = make([][]byte, len(r.keys))
+		for i, pub := range r.keys {
+			// the user ID has been checked, CalculateZA can not fail
+			r.zas[i], _ = sm2.CalculateZA(pub, r.UID(i))
+		}
+	}
+	seen := make(map[string]int, len(r.keys))
+	for i, pub := range r.keys {
+		key := string(appendUncompressed(nil, pub.X, pub.Y))
+		if j, ok := seen[key]; ok {
+			return nil, fmt.Errorf("%w: members %d and %d", ErrDuplicatePublicKey, j, i)
+		}
+		seen[key] = i
+	}
+	if o.proofs {
+		proofs := make([][]byte, len(members))
+		for i, m := range members {
+			proofs[i] = m.Proof
+		}
+		if err := r.verifyProofs(proofs, order); err != nil {
+			return nil, err
+		}
+		r.proven = true
+	}
+	return r, nil
+}
+
+// newRing returns a ring holding copies of pubs without validating them.
+// It backs the constructors which take a plain slice of public keys, whose
+// rings are validated on use instead.
+func newRing(pubs []*ecdsa.PublicKey) *Ring {
+	r := &Ring{keys: make([]*ecdsa.PublicKey, len(pubs))}
+	for i, pub := range pubs {
+		r.keys[i] = copyPublicKey(pub)
+	}
+	return r
+}
+
+func copyPublicKey(pub *ecdsa.PublicKey) *ecdsa.PublicKey {
+	if pub == nil {
+		return nil
+	}
+	cp := &ecdsa.PublicKey{Curve: pub.Curve}
+	if pub.X != nil {
+		cp.X = new(big.Int).Set(pub.X)
+	}
+	if pub.Y != nil {
+		cp.Y = new(big.Int).Set(pub.Y)
+	}
+	return cp
+}
+
+// Len returns the number of ring members.
+func (r *Ring) Len() int {
+	return len(r.keys)
+}
+
+// PublicKey returns a copy of the i-th ring member.
+func (r *Ring) PublicKey(i int) *ecdsa.PublicKey {
+	return copyPublicKey(r.keys[i])
+}
+
+// PublicKeys returns copies of the ring members in ring order.
+func (r *Ring) PublicKeys() []*ecdsa.PublicKey {
+	pubs := make([]*ecdsa.PublicKey, len(r.keys))
+	for i, pub := range r.keys {
+		pubs[i] = copyPublicKey(pub)
+	}
+	return pubs
+}
+
+// UID returns the user ID of the i-th ring member, see RingMember. It
+// returns the default user ID if the member has none.
+func (r *Ring) UID(i int) []byte {
+	if r.uids == nil || len(r.uids[i]) == 0 {
+		return append([]byte{}, defaultUID...)
+	}
+	return append([]byte{}, r.uids[i]...)
+}
+
+// ID returns the ring identifier, see RingID. With WithZABinding, the ZA
+// value of every member is hashed after its encoding.
+func (r *Ring) ID() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.id == nil {
+		if r.zas == nil {
+			r.id = RingID(r.keys)
+		} else {
+			h := newRingIDHash()
+			for i, pub := range r.keys {
+				writeRingIDMember(h, pub)
+				h.Write(r.zas[i])
+			}
+			r.id = h.Sum(nil)
+		}
+	}
+	return append([]byte{}, r.id...)
+}
+
+// RingID returns the stable identifier of a ring version: the SM3 hash of a
+// fixed prefix followed by the compressed SEC 1 encodings of the members in
+// ring order. Rings with the same members in the same order share an ID.
+func RingID(pubs []*ecdsa.PublicKey) []byte {
+	h := newRingIDHash()
+	for _, pub := range pubs {
+		writeRingIDMember(h, pub)
+	}
+	return h.Sum(nil)
+}
+
+func newRingIDHash() hash.Hash {
+	h := sm3.New()
+	h.Write([]byte(ringIDPrefix))
+	return h
+}
+
+func writeRingIDMember(h hash.Hash, pub *ecdsa.PublicKey) {
+	if pub == nil || !validPoint(pub.X, pub.Y) {
+		// not a valid ring, but keep the ID well defined
+		h.Write(make([]byte, compressedPointSize))
+		return
+	}
+	h.Write(elliptic.MarshalCompressed(pub.Curve, pub.X, pub.Y))
+}
+
+// hashPrefix returns the state of an SM3 hash which has absorbed the
+// coordinates of all members, each followed by its ZA value with
+// WithZABinding, the common prefix of the challenge hashes of the ring. Use
+// resumeSM3 to continue hashing from it. The ring must be valid.
+func (r *Ring) hashPrefix() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.prefix == nil {
+		h := sm3.New()
+		for i, pub := range r.keys {
+			writeMember(h, pub)
+			if r.zas != nil {
+				h.Write(r.zas[i])
+			}
+		}
+		r.prefix = marshalSM3(h)
+	}
+	return r.prefix
+}
+
+// sumPoint returns the sum of the members, the legacy linkability base
+// point, see publicKeysToPoint. The ring must be valid.
+func (r *Ring) sumPoint() (x, y *big.Int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sumX == nil {
+		r.sumX, r.sumY = publicKeysToPoint(r.keys)
+	}
+	return r.sumX, r.sumY
+}
+
+// hashToCurvePoint returns the linkability base point derived with
+// hashRingToPoint. The ring must be valid.
+func (r *Ring) hashToCurvePoint() (x, y *big.Int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.h2cX == nil {
+		r.h2cX, r.h2cY = hashRingToPoint(r.keys)
+	}
+	return r.h2cX, r.h2cY
+}
+
+// writeMember writes the fixed-width coordinates of pub into h.
+func writeMember(h hash.Hash, pub *ecdsa.PublicKey) {
+	var buffer [scalarSize]byte
+	pub.X.FillBytes(buffer[:])
+	h.Write(buffer[:])
+	pub.Y.FillBytes(buffer[:])
+	h.Write(buffer[:])
+}
+
+// marshalSM3 returns the internal state of h, which was created by sm3.New.
+func marshalSM3(h hash.Hash) []byte {
+	// the SM3 digest of gmsm always implements encoding.BinaryMarshaler
+	state, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		panic("sm2rsign: " + err.Error())
+	}
+	return state
+}
+
+// resumeSM3 returns a new SM3 hash in the state returned by marshalSM3.
+func resumeSM3(state []byte) hash.Hash {
+	h := sm3.New()
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+		panic("sm2rsign: " + err.Error())
+	}
+	return h
+}
+
+// Index returns the position of pub in the ring, or -1 if it is not a
+// member.
+func (r *Ring) Index(pub *ecdsa.PublicKey) int {
+	if pub == nil {
+		return -1
+	}
+	for i, member := range r.keys {
+		if member != nil && member.Equal(pub) {
+			return i
+		}
+	}
+	return -1
+}
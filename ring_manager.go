@@ -0,0 +1,163 @@
+package sm2rsign
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"sync"
+
+	"github.com/emmansun/gmsm/sm2"
+	"github.com/emmansun/gmsm/sm3"
+)
+
+// ErrNotRingMember is returned by RingManager.Remove for a public key which
+// is not a member of the ring.
+var ErrNotRingMember = errors.New("sm2rsign: public key is not a ring member")
+
+// RingManager maintains a ring whose members change over time and produces
+// immutable Ring snapshots of it, one per ring version.
+//
+// New members are appended to the ring, so the data derived from the
+// members, i.e. the ring ID, the common prefix of the challenge hashes and
+// both linkability base points, is carried over from the previous version
+// and updated in constant time. Removing a member updates the legacy base
+// point in constant time too, while the hashes are recomputed once, when the
+// next snapshot is taken. Either way, no snapshot repeats the scalar
+// multiplications or point additions over the whole ring.
+//
+// A RingManager is safe for concurrent use.
+type RingManager struct {
+	mu      sync.Mutex
+	keys    []*ecdsa.PublicKey
+	members map[string]bool
+
+	// running state, see Ring for the data derived from it
+	id, prefix, h2c hash.Hash
+	stale           bool // the hashes must be recomputed after Remove
+	sum             point
+
+	snapshot *Ring
+}
+
+// NewRingManager creates a manager whose ring initially holds pubs, in that
+// order. It returns an error wrapping ErrNonSM2PublicKey,
+// ErrInvalidPublicKey or ErrDuplicatePublicKey if a member is not valid.
+// Unlike NewRing, pubs may hold fewer than two keys.
+func NewRingManager(pubs []*ecdsa.PublicKey) (*RingManager, error) {
+	m := &RingManager{members: make(map[string]bool, len(pubs))}
+	m.resetHashes()
+	for i, pub := range pubs {
+		if err := m.add(pub); err != nil {
+			return nil, fmt.Errorf("%w: member %d", err, i)
+		}
+	}
+	return m, nil
+}
+
+func (m *RingManager) resetHashes() {
+	m.id = newRingIDHash()
+	m.prefix = sm3.New()
+	m.h2c = newXMDHash()
+	m.stale = false
+}
+
+// Add appends pub to the ring. It returns an error wrapping
+// ErrNonSM2PublicKey, ErrInvalidPublicKey or ErrDuplicatePublicKey if pub can
+// not join the ring.
+func (m *RingManager) Add(pub *ecdsa.PublicKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.add(pub)
+}
+
+func (m *RingManager) add(pub *ecdsa.PublicKey) error {
+	if err := checkPublicKey(pub); err != nil {
+		return err
+	}
+	key := string(appendUncompressed(nil, pub.X, pub.Y))
+	if m.members[key] {
+		return ErrDuplicatePublicKey
+	}
+	pub = copyPublicKey(pub)
+	m.members[key] = true
+	m.keys = append(m.keys, pub)
+	if !m.stale {
+		writeRingIDMember(m.id, pub)
+		writeMember(m.prefix, pub)
+		writeXMDMember(m.h2c, pub)
+	}
+	m.sum.addBig(pub.X, pub.Y)
+	m.snapshot = nil
+	return nil
+}
+
+// Remove removes pub from the ring, keeping the order of the remaining
+// members. It returns ErrNotRingMember if pub is not a member.
+func (m *RingManager) Remove(pub *ecdsa.PublicKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if pub == nil || !validPoint(pub.X, pub.Y) {
+		return ErrNotRingMember
+	}
+	key := string(appendUncompressed(nil, pub.X, pub.Y))
+	if !m.members[key] {
+		return ErrNotRingMember
+	}
+	delete(m.members, key)
+	for i, member := range m.keys {
+		if member.X.Cmp(pub.X) == 0 && member.Y.Cmp(pub.Y) == 0 {
+			m.keys = append(m.keys[:i:i], m.keys[i+1:]...)
+			break
+		}
+	}
+	negY := new(big.Int).Sub(sm2.P256().Params().P, pub.Y)
+	m.sum.addBig(pub.X, negY)
+	m.stale = true
+	m.snapshot = nil
+	return nil
+}
+
+// Len returns the current number of ring members.
+func (m *RingManager) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.keys)
+}
+
+// Ring returns a snapshot of the current ring version. Snapshots are not
+// affected by later changes, and the same snapshot is returned until the
+// ring changes. It returns ErrRingTooSmall if the ring has fewer than two
+// members.
+func (m *RingManager) Ring() (*Ring, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.keys) < 2 {
+		return nil, ErrRingTooSmall
+	}
+	if m.snapshot != nil {
+		return m.snapshot, nil
+	}
+	if m.stale {
+		m.resetHashes()
+		for _, pub := range m.keys {
+			writeRingIDMember(m.id, pub)
+			writeMember(m.prefix, pub)
+			writeXMDMember(m.h2c, pub)
+		}
+	}
+	// the key copies are owned by the manager and never modified, so the
+	// snapshots can share them
+	r := &Ring{
+		keys:   append([]*ecdsa.PublicKey{}, m.keys...),
+		id:     m.id.Sum(nil),
+		prefix: marshalSM3(m.prefix),
+	}
+	r.sumX, r.sumY = m.sum.affine()
+	h2c := resumeSM3(marshalSM3(m.h2c))
+	// the DST is a valid constant, hashToCurveXMD can not fail
+	r.h2cX, r.h2cY, _ = hashToCurveXMD(h2c, []byte(ringHashToCurveDST))
+	m.snapshot = r
+	return r, nil
+}
@@ -0,0 +1,141 @@
+package sm2rsign
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/emmansun/gmsm/sm2"
+)
+
+// checkSnapshot checks that the data derived incrementally by a RingManager
+// matches the data derived from scratch.
+func checkSnapshot(t *testing.T, ring *Ring) {
+	t.Helper()
+	fresh, err := NewRing(ring.PublicKeys())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ring.ID(), fresh.ID()) {
+		t.Errorf("ring ID mismatch")
+	}
+	if !bytes.Equal(ring.hashPrefix(), fresh.hashPrefix()) {
+		t.Errorf("hash prefix mismatch")
+	}
+	x1, y1 := ring.sumPoint()
+	x2, y2 := fresh.sumPoint()
+	if x1.Cmp(x2) != 0 || y1.Cmp(y2) != 0 {
+		t.Errorf("legacy base point mismatch")
+	}
+	x1, y1 = ring.hashToCurvePoint()
+	x2, y2 = fresh.hashToCurvePoint()
+	if x1.Cmp(x2) != 0 || y1.Cmp(y2) != 0 {
+		t.Errorf("hash to curve base point mismatch")
+	}
+}
+
+func TestRingManager(t *testing.T) {
+	keys := make([]*sm2.PrivateKey, 6)
+	for i := range keys {
+		keys[i], _ = sm2.GenerateKey(rand.Reader)
+	}
+	m, err := NewRingManager([]*ecdsa.PublicKey{&keys[0].PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Ring(); !errors.Is(err, ErrRingTooSmall) {
+		t.Errorf("got %v, want %v", err, ErrRingTooSmall)
+	}
+	for _, key := range keys[1:4] {
+		if err := m.Add(&key.PublicKey); err != nil {
+			t.Fatal(err)
+		}
+	}
+	v1, err := m.Ring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := m.Ring(); again != v1 {
+		t.Errorf("unchanged ring produced a new snapshot")
+	}
+	checkSnapshot(t, v1)
+
+	if err := m.Remove(&keys[1].PublicKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Add(&keys[4].PublicKey); err != nil {
+		t.Fatal(err)
+	}
+	v2, err := m.Ring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSnapshot(t, v2)
+	if err := m.Add(&keys[5].PublicKey); err != nil {
+		t.Fatal(err)
+	}
+	v3, err := m.Ring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSnapshot(t, v3)
+
+	if v1.Len() != 4 || v1.Index(&keys[1].PublicKey) != 1 || v2.Len() != 4 || v2.Index(&keys[1].PublicKey) != -1 || v3.Len() != 5 || m.Len() != 5 {
+		t.Errorf("snapshots changed with the ring")
+	}
+
+	msg := []byte("hello world")
+	signer := keys[2]
+	for _, opts := range [][]LinkableOption{nil, {WithHashToCurve()}} {
+		tests := []struct {
+			signer   RingSigner
+			verifier RingVerifier
+		}{
+			{NewBaseLinkableSignerWithRing(signer, v3, opts...), NewBaseLinkableVerfier(v3.PublicKeys(), opts...)},
+			{NewLinkableSignerVariant1WithRing(signer, v3, opts...), NewLinkableVerfierVariant1(v3.PublicKeys(), opts...)},
+			{NewLinkableSignerVariant2WithRing(signer, v3, opts...), NewLinkableVerfierVariant2(v3.PublicKeys(), opts...)},
+		}
+		for _, tt := range tests {
+			sig, err := tt.signer.Sign(rand.Reader, SimpleParticipantRandInt, msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.verifier.Verify(msg, sig) {
+				t.Errorf("%v: failed to verify a signature over a snapshot", sig.Scheme)
+			}
+		}
+	}
+	sig, err := SignRing(rand.Reader, SimpleParticipantRandInt, signer, v2, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyWithError(v2.PublicKeys(), msg, sig); err != nil {
+		t.Errorf("failed to verify a signature over a snapshot: %v", err)
+	}
+}
+
+func TestRingManagerErrors(t *testing.T) {
+	key1, _ := sm2.GenerateKey(rand.Reader)
+	key2, _ := sm2.GenerateKey(rand.Reader)
+	if _, err := NewRingManager([]*ecdsa.PublicKey{&key1.PublicKey, &key1.PublicKey}); !errors.Is(err, ErrDuplicatePublicKey) {
+		t.Errorf("got %v, want %v", err, ErrDuplicatePublicKey)
+	}
+	m, _ := NewRingManager([]*ecdsa.PublicKey{&key1.PublicKey})
+	if err := m.Add(nil); !errors.Is(err, ErrNonSM2PublicKey) {
+		t.Errorf("got %v, want %v", err, ErrNonSM2PublicKey)
+	}
+	if err := m.Add(&key1.PublicKey); !errors.Is(err, ErrDuplicatePublicKey) {
+		t.Errorf("got %v, want %v", err, ErrDuplicatePublicKey)
+	}
+	if err := m.Remove(&key2.PublicKey); !errors.Is(err, ErrNotRingMember) {
+		t.Errorf("got %v, want %v", err, ErrNotRingMember)
+	}
+	if err := m.Remove(&key1.PublicKey); err != nil {
+		t.Fatal(err)
+	}
+	if m.Len() != 0 {
+		t.Errorf("got %d members, want 0", m.Len())
+	}
+}
@@ -0,0 +1,111 @@
+package sm2rsign
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrMissingRingID is returned when a signature has to be verified against
+// the ring it names, but does not carry a ring ID.
+var ErrMissingRingID = errors.New("sm2rsign: signature does not carry a ring ID")
+
+// RingResolver looks up the ring version with the given ID, see RingID. It
+// returns an error wrapping ErrUnknownRing if there is no such ring.
+//
+// Ring IDs are derived from the member keys, so a resolver does not need to
+// be trusted for integrity: the verifiers check that the ring they are given
+// hashes to the ID of the signature.
+type RingResolver interface {
+	ResolveRing(id []byte) (*Ring, error)
+}
+
+// RingRegistry is an in-memory RingResolver. Rings are never removed, so
+// signatures made over any ring version which was ever registered keep
+// verifying after members join or leave. It is safe for concurrent use.
+type RingRegistry struct {
+	mu    sync.RWMutex
+	rings map[string]*Ring
+}
+
+// NewRingRegistry creates an empty registry.
+func NewRingRegistry() *RingRegistry {
+	return &RingRegistry{rings: make(map[string]*Ring)}
+}
+
+// Register adds ring to the registry and returns its ID. Registering a ring
+// with the same members in the same order again is a no-op.
+func (r *RingRegistry) Register(ring *Ring) []byte {
+	id := ring.ID()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.rings[string(id)]; !ok {
+		r.rings[string(id)] = ring
+	}
+	return id
+}
+
+// ResolveRing implements RingResolver.
+func (r *RingRegistry) ResolveRing(id []byte) (*Ring, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ring, ok := r.rings[string(id)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %x", ErrUnknownRing, id)
+	}
+	return ring, nil
+}
+
+// resolveRing resolves the ring named by a signature.
+func resolveRing(resolver RingResolver, id []byte) (*Ring, error) {
+	if len(id) == 0 {
+		return nil, ErrMissingRingID
+	}
+	ring, err := resolver.ResolveRing(id)
+	if err != nil {
+		return nil, err
+	}
+	if ring == nil {
+		return nil, fmt.Errorf("%w: %x", ErrUnknownRing, id)
+	}
+	return ring, nil
+}
+
+// VerifyWithResolver verifies a ring signature against the ring version it
+// was made over, as looked up by resolver. Signatures without a ring ID are
+// rejected with ErrMissingRingID; other failures are reported as by
+// VerifyRingWithError.
+func VerifyWithResolver(resolver RingResolver, msg []byte, signature *RingSignature) error {
+	if signature == nil {
+		return ErrMissingRingID
+	}
+	ring, err := resolveRing(resolver, signature.RingID)
+	if err != nil {
+		return err
+	}
+	return VerifyRingWithError(ring, msg, signature)
+}
+
+// VerifyLinkableWithResolver is like VerifyWithResolver for linkable ring
+// signatures of the base, variant1 and variant2 schemes, which are verified
+// with the given options. Scoped signatures also need their scope: resolve
+// the ring and verify them with NewScopedLinkableVerfierWithRing instead.
+func VerifyLinkableWithResolver(resolver RingResolver, msg []byte, signature *LinkableRingSignature, opts ...LinkableOption) error {
+	if signature == nil {
+		return ErrMissingRingID
+	}
+	ring, err := resolveRing(resolver, signature.RingID)
+	if err != nil {
+		return err
+	}
+	switch signature.Scheme {
+	case LinkableSchemeBase:
+		return NewBaseLinkableVerfierWithRing(ring, opts...).VerifyWithError(msg, signature)
+	case LinkableSchemeVariant1:
+		return NewLinkableVerfierVariant1WithRing(ring, opts...).VerifyWithError(msg, signature)
+	case LinkableSchemeVariant2:
+		return NewLinkableVerfierVariant2WithRing(ring, opts...).VerifyWithError(msg, signature)
+	default:
+		return fmt.Errorf("%w: %v signatures can not be verified by ring ID alone", ErrSchemeMismatch, signature.Scheme)
+	}
+}
@@ -0,0 +1,142 @@
+package sm2rsign
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/emmansun/gmsm/sm2"
+)
+
+func TestRingID(t *testing.T) {
+	key1, _ := sm2.GenerateKey(rand.Reader)
+	key2, _ := sm2.GenerateKey(rand.Reader)
+	key3, _ := sm2.GenerateKey(rand.Reader)
+	ring, _ := NewRing([]*ecdsa.PublicKey{&key1.PublicKey, &key2.PublicKey})
+	same, _ := NewRing([]*ecdsa.PublicKey{&key1.PublicKey, &key2.PublicKey})
+	swapped, _ := NewRing([]*ecdsa.PublicKey{&key2.PublicKey, &key1.PublicKey})
+	grown, _ := NewRing([]*ecdsa.PublicKey{&key1.PublicKey, &key2.PublicKey, &key3.PublicKey})
+
+	if len(ring.ID()) != 32 || !bytes.Equal(ring.ID(), same.ID()) {
+		t.Errorf("rings with the same members have different IDs")
+	}
+	if bytes.Equal(ring.ID(), swapped.ID()) || bytes.Equal(ring.ID(), grown.ID()) {
+		t.Errorf("different rings share an ID")
+	}
+	ring.ID()[0] ^= 1
+	if !bytes.Equal(ring.ID(), same.ID()) {
+		t.Errorf("ring ID changed after the returned slice was modified")
+	}
+}
+
+func TestSignatureRingID(t *testing.T) {
+	signer, _ := sm2.GenerateKey(rand.Reader)
+	key1, _ := sm2.GenerateKey(rand.Reader)
+	key2, _ := sm2.GenerateKey(rand.Reader)
+	pubs := []*ecdsa.PublicKey{&key1.PublicKey, &signer.PublicKey}
+	other := []*ecdsa.PublicKey{&key2.PublicKey, &signer.PublicKey}
+	msg := []byte("hello world")
+
+	sig, err := Sign(rand.Reader, SimpleParticipantRandInt, signer, pubs, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sig.RingID, RingID(pubs)) {
+		t.Fatalf("signature does not carry the ring ID")
+	}
+	der, err := sig.MarshalASN1()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseRingSignature(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(parsed.RingID, sig.RingID) || !Verify(pubs, msg, parsed) {
+		t.Errorf("ring ID was not preserved by the ASN.1 encoding")
+	}
+
+	// an ID of another ring is rejected before the ring equation is checked
+	sig.RingID = RingID(other)
+	if err := VerifyWithError(pubs, msg, sig); !errors.Is(err, ErrRingIDMismatch) {
+		t.Errorf("got %v, want %v", err, ErrRingIDMismatch)
+	}
+	// signatures without an ID, e.g. legacy ones, still verify
+	sig.RingID = nil
+	if err := VerifyWithError(pubs, msg, sig); err != nil {
+		t.Errorf("failed to verify a signature without ring ID: %v", err)
+	}
+
+	ring, _ := NewRing(pubs)
+	linkable, err := NewBaseLinkableSignerWithRing(signer, ring).Sign(rand.Reader, SimpleParticipantRandInt, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(linkable.RingID, ring.ID()) {
+		t.Fatalf("linkable signature does not carry the ring ID")
+	}
+	linkable.RingID = RingID(other)
+	if err := NewBaseLinkableVerfierWithRing(ring).VerifyWithError(msg, linkable); !errors.Is(err, ErrRingIDMismatch) {
+		t.Errorf("got %v, want %v", err, ErrRingIDMismatch)
+	}
+}
+
+func TestVerifyWithResolver(t *testing.T) {
+	signer, _ := sm2.GenerateKey(rand.Reader)
+	key1, _ := sm2.GenerateKey(rand.Reader)
+	key2, _ := sm2.GenerateKey(rand.Reader)
+	msg := []byte("hello world")
+
+	registry := NewRingRegistry()
+	v1, _ := NewRing([]*ecdsa.PublicKey{&signer.PublicKey, &key1.PublicKey})
+	registry.Register(v1)
+	sig1, err := SignRing(rand.Reader, SimpleParticipantRandInt, signer, v1, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	linkable1, err := NewLinkableSignerVariant2WithRing(signer, v1).Sign(rand.Reader, SimpleParticipantRandInt, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// key1 leaves and key2 joins, old signatures keep verifying
+	v2, _ := NewRing([]*ecdsa.PublicKey{&signer.PublicKey, &key2.PublicKey})
+	if !bytes.Equal(registry.Register(v2), v2.ID()) {
+		t.Fatalf("Register did not return the ring ID")
+	}
+	sig2, err := SignRing(rand.Reader, SimpleParticipantRandInt, signer, v2, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sig := range []*RingSignature{sig1, sig2} {
+		if err := VerifyWithResolver(registry, msg, sig); err != nil {
+			t.Errorf("failed to verify with the resolved ring: %v", err)
+		}
+	}
+	if err := VerifyLinkableWithResolver(registry, msg, linkable1); err != nil {
+		t.Errorf("failed to verify the linkable signature with the resolved ring: %v", err)
+	}
+	if err := VerifyWithResolver(registry, []byte("another message"), sig1); !errors.Is(err, ErrRingEquation) {
+		t.Errorf("got %v, want %v", err, ErrRingEquation)
+	}
+
+	unknown, _ := NewRing([]*ecdsa.PublicKey{&key1.PublicKey, &key2.PublicKey})
+	sig1.RingID = unknown.ID()
+	if err := VerifyWithResolver(registry, msg, sig1); !errors.Is(err, ErrUnknownRing) {
+		t.Errorf("got %v, want %v", err, ErrUnknownRing)
+	}
+	sig1.RingID = nil
+	if err := VerifyWithResolver(registry, msg, sig1); !errors.Is(err, ErrMissingRingID) {
+		t.Errorf("got %v, want %v", err, ErrMissingRingID)
+	}
+
+	scoped, err := NewScopedLinkableSignerWithRing(signer, v2, []byte("scope")).Sign(rand.Reader, SimpleParticipantRandInt, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyLinkableWithResolver(registry, msg, scoped); !errors.Is(err, ErrSchemeMismatch) {
+		t.Errorf("got %v, want %v", err, ErrSchemeMismatch)
+	}
+}
@@ -1,9 +1,12 @@
 package sm2rsign
 
 import (
+	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"errors"
+	"fmt"
 	"io"
 	"math/big"
 
@@ -15,6 +18,41 @@ var (
 	one = new(big.Int).SetInt64(1)
 )
 
+// Signing failures. The errors returned by Sign and the RingSigner
+// implementations wrap one of these, or the error of a custom
+// ParticipantRandInt or ParticipantStrategy, and can be matched with
+// errors.Is and errors.As.
+var (
+	ErrInvalidPrivateKey = errors.New("sm2rsign: invalid SM2 private key")
+	ErrSignerNotInRing   = errors.New("sm2rsign: does not contain public key of the private key")
+	ErrRandomSource      = errors.New("sm2rsign: failed to read from random source")
+)
+
+// Ring failures, shared by signing and verification.
+var (
+	ErrRingTooSmall       = errors.New("sm2rsign: require multiple SM2 public keys")
+	ErrNonSM2PublicKey    = errors.New("sm2rsign: contains non SM2 public key")
+	ErrInvalidPublicKey   = errors.New("sm2rsign: invalid SM2 public key in ring")
+	ErrDuplicatePublicKey = errors.New("sm2rsign: duplicate public key in ring")
+	ErrInvalidBasePoint   = errors.New("sm2rsign: linkability base point of the ring is the point at infinity")
+	ErrInvalidUID         = errors.New("sm2rsign: SM2 user ID is too long")
+	ErrInvalidProof       = errors.New("sm2rsign: invalid proof of possession of ring member key")
+	ErrRingNotProven      = errors.New("sm2rsign: ring members did not prove possession of their keys")
+)
+
+// Verification failures. The errors returned by the VerifyWithError
+// functions wrap one of these, or one of the ring failures, and can be
+// matched with errors.Is.
+var (
+	ErrRingSizeMismatch = errors.New("sm2rsign: signature does not match ring size")
+	ErrRingIDMismatch   = errors.New("sm2rsign: signature was made over another ring")
+	ErrUnknownRing      = errors.New("sm2rsign: unknown ring")
+	ErrSchemeMismatch   = errors.New("sm2rsign: signature was produced by another scheme")
+	ErrInvalidKeyImage  = errors.New("sm2rsign: invalid key image")
+	ErrScalarOutOfRange = errors.New("sm2rsign: signature scalar out of range")
+	ErrRingEquation     = errors.New("sm2rsign: ring equation does not hold")
+)
+
 type ParticipantRandInt func(rand io.Reader, pub *ecdsa.PublicKey, msg []byte) (*big.Int, error)
 
 func SimpleParticipantRandInt(rand io.Reader, pub *ecdsa.PublicKey, msg []byte) (*big.Int, error) {
@@ -23,8 +61,16 @@ func SimpleParticipantRandInt(rand io.Reader, pub *ecdsa.PublicKey, msg []byte)
 
 // https://www.wangan.com/p/7fyg8kdf13655a55
 // 完全采用了sm2签名随机数r的生成方式，只是这里我们使用的默认uid
+// 如需使用各成员自己的uid，请使用SM2UIDParticipants
+// 预签名时消息未知，msg为nil，参见PresignRing
 func SM2ParticipantRandInt(rand io.Reader, pub *ecdsa.PublicKey, msg []byte) (*big.Int, error) {
-	m, err := calculateSM2Hash(pub, msg, nil)
+	return sm2ParticipantRandInt(rand, pub, msg, nil)
+}
+
+// sm2ParticipantRandInt is SM2ParticipantRandInt with the user ID uid, or
+// the default user ID if uid is empty.
+func sm2ParticipantRandInt(rand io.Reader, pub *ecdsa.PublicKey, msg, uid []byte) (*big.Int, error) {
+	m, err := calculateSM2Hash(pub, msg, uid)
 	if err != nil {
 		return nil, err
 	}
@@ -36,13 +82,16 @@ func SM2ParticipantRandInt(rand io.Reader, pub *ecdsa.PublicKey, msg []byte) (*b
 			return nil, err
 		}
 
-		r, _ := pub.Curve.ScalarBaseMult(k.Bytes()) // (x, y) = k*G
-		r.Add(r, e)                                 // r = x + e
-		r.Mod(r, pub.Curve.Params().N)              // r = (x + e) mod N
+		var kG point
+		r, _ := kG.scalarBaseMult(k).affine() // (x, y) = k*G
+		r.Add(r, e)                           // r = x + e
+		r.Mod(r, pub.Curve.Params().N)        // r = (x + e) mod N
 		if r.Sign() != 0 {
 			s := new(big.Int).Add(r, k)
 			if s.Cmp(pub.Curve.Params().N) != 0 { // if r != 0 && (r + k) != N then ok
-				return s, nil
+				// s is only used modulo N, reduce it so that it fits a
+				// fixed-width scalar encoding.
+				return s.Mod(s, pub.Curve.Params().N), nil
 			}
 		}
 	}
@@ -74,7 +123,7 @@ func randFieldElement(c elliptic.Curve, rand io.Reader) (k *big.Int, err error)
 		N := c.Params().N
 		b := make([]byte, (N.BitLen()+7)/8)
 		if _, err = io.ReadFull(rand, b); err != nil {
-			return
+			return nil, fmt.Errorf("%w: %w", ErrRandomSource, err)
 		}
 		if excess := len(b)*8 - N.BitLen(); excess > 0 {
 			b[0] >>= excess
@@ -86,22 +135,67 @@ func randFieldElement(c elliptic.Curve, rand io.Reader) (k *big.Int, err error)
 	}
 }
 
+// challengeHasher computes the challenges of the steps of a ring signature.
+// A challenge hashes the ring members, msg and the point of the step:
+//
+//	SM3(x_1 || y_1 || ... || x_n || y_n || msg || cx || cy)
+//
+// The data shared by all steps is absorbed once, and the SM3 state after it
+// is saved, so that each step only hashes its own point. The challenges are
+// the same as those of the original implementation, which rehashed the ring
+// and the message at every step, so existing signatures still verify.
 // 这个hash算法没有给出明确定义
-func hash(pubs []*ecdsa.PublicKey, msg []byte, cx, cy *big.Int) *big.Int {
+type challengeHasher []byte
+
+// newChallengeHasher returns a challengeHasher over the ring whose members
+// were absorbed into the state prefix, see Ring.hashPrefix, followed by the
+// shared data.
+func newChallengeHasher(prefix []byte, shared ...[]byte) challengeHasher {
+	h := resumeSM3(prefix)
+	for _, b := range shared {
+		h.Write(b)
+	}
+	return marshalSM3(h)
+}
+
+// hash returns the challenge of a step whose points have the coordinates
+// coords.
+func (ch challengeHasher) hash(coords ...*big.Int) *big.Int {
 	var buffer [32]byte
-	h := sm3.New()
-	for _, pub := range pubs {
-		pub.X.FillBytes(buffer[:])
-		h.Write(buffer[:])
-		pub.Y.FillBytes(buffer[:])
+	h := resumeSM3(ch)
+	for _, v := range coords {
+		v.FillBytes(buffer[:])
 		h.Write(buffer[:])
 	}
-	h.Write(msg)
-	cx.FillBytes(buffer[:])
-	h.Write(buffer[:])
-	cy.FillBytes(buffer[:])
-	h.Write(buffer[:])
-	return hashToInt(h.Sum(nil), pubs[0].Curve)
+	return challengeToInt(h.Sum(nil))
+}
+
+// challengeToInt converts the SM3 digest of a step to its challenge. It is a
+// variable so that tests can force challenges of N or larger, which honest
+// signatures only have with probability about 2^-32.
+var challengeToInt = func(digest []byte) *big.Int {
+	return hashToInt(digest, sm2.P256())
+}
+
+// ringStep returns sG + cP_i, the point hashed at step i of a ring
+// signature, where P_i is the i-th member of ring. The tables of prepared
+// are used if it is not nil. s and c are public, so the arithmetic does not
+// need to be constant time.
+func ringStep(prepared *PreparedRing, ring *Ring, i int, s, c *big.Int) (x, y *big.Int) {
+	if prepared == nil {
+		pub := ring.keys[i]
+		return combinedMult(pub.X, pub.Y, s, c)
+	}
+	var acc point
+	baseTable().mulAdd(&acc, s)
+	return ringStepFrom(&acc, prepared, ring, i, c)
+}
+
+// ringStepFrom is like ringStep, where acc = sG was computed beforehand,
+// see participantSteps. The result is accumulated into acc.
+func ringStepFrom(acc *point, prepared *PreparedRing, ring *Ring, i int, c *big.Int) (x, y *big.Int) {
+	prepared.member(ring, i).mulAdd(acc, c)
+	return acc.affine()
 }
 
 // hashToInt converts a hash value to an integer. Per FIPS 186-4, Section 6.4,
@@ -122,128 +216,221 @@ func hashToInt(hash []byte, c elliptic.Curve) *big.Int {
 	return ret
 }
 
-// A invertible implements fast inverse in GF(N).
-type invertible interface {
-	// Inverse returns the inverse of k mod Params().N.
-	Inverse(k *big.Int) *big.Int
+// validScalar reports whether k is in [0, N).
+func validScalar(k *big.Int) bool {
+	return k != nil && k.Sign() >= 0 && k.Cmp(sm2.P256().Params().N) < 0
 }
 
-func getPai(priv *sm2.PrivateKey, pubs []*ecdsa.PublicKey) (int, error) {
-	n := len(pubs)
-	if n < 2 {
-		return -1, errors.New("require multiple SM2 public keys")
+// validPoint reports whether (x, y) is a point on the SM2 curve other than
+// the point at infinity.
+func validPoint(x, y *big.Int) bool {
+	if x == nil || y == nil {
+		return false
 	}
-	var pai int = -1
-	for i := 0; i < len(pubs); i++ {
-		if pubs[i].Curve != priv.Curve {
-			return -1, errors.New("contains non SM2 public key")
-		}
-		if priv.PublicKey.Equal(pubs[i]) {
-			pai = i
-			break
-		}
+	_, ok := newAffinePoint(x, y)
+	return ok
+}
+
+// validChallenge reports whether c is in [0, 2^256). Every scheme stores
+// the challenge c_0 as its challenge function gives it, as the original
+// implementation did: the SM3 hash, which may be N or larger, except for
+// variant 2, whose challenges are reduced modulo N. Verifiers compare c_0
+// exactly, not modulo N, so each signature has a single valid c, and only
+// the signer's response reduces it, see signerResponse.
+func validChallenge(c *big.Int) bool {
+	return c != nil && c.Sign() >= 0 && c.BitLen() <= 256
+}
+
+// checkScalars checks that c is a challenge, see validChallenge, and that
+// every s_i is in [0, N).
+func checkScalars(c *big.Int, s []*big.Int) error {
+	if !validChallenge(c) {
+		return fmt.Errorf("%w: c", ErrScalarOutOfRange)
 	}
-	if pai < 0 {
-		return -1, errors.New("does not contain public key of the private key")
+	for i, k := range s {
+		if !validScalar(k) {
+			return fmt.Errorf("%w: s_%d", ErrScalarOutOfRange, i+1)
+		}
 	}
-	return pai, nil
+	return nil
 }
 
-// http://www.jcr.cacrnet.org.cn/CN/10.13868/j.cnki.jcr.000472
-func Sign(rand io.Reader, participantRandInt ParticipantRandInt, priv *sm2.PrivateKey, pubs []*ecdsa.PublicKey, msg []byte) ([]*big.Int, error) {
-	n := len(pubs)
-	pai, err := getPai(priv, pubs)
-	if err != nil {
-		return nil, err
+// checkPrivateKey checks that priv is an SM2 private key whose D is in
+// [1, N-2], so that 1+D is invertible, and whose public key matches D.
+func checkPrivateKey(priv *sm2.PrivateKey) error {
+	if priv == nil || priv.Curve != sm2.P256() || priv.D == nil {
+		return ErrInvalidPrivateKey
+	}
+	nMinus1 := new(big.Int).Sub(priv.Params().N, one)
+	if priv.D.Sign() <= 0 || priv.D.Cmp(nMinus1) >= 0 {
+		return ErrInvalidPrivateKey
 	}
-	// Step 1
-	kPai, err := randFieldElement(priv, rand)
+	x, y, err := secretMult(nil, priv.D)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if priv.X == nil || priv.Y == nil || x.Cmp(priv.X) != 0 || y.Cmp(priv.Y) != 0 {
+		return ErrInvalidPrivateKey
 	}
-	kPaiGx, kPaiGy := priv.ScalarBaseMult(kPai.Bytes())
-	c := hash(pubs, msg, kPaiGx, kPaiGy)
+	return nil
+}
 
-	results := make([]*big.Int, n+1)
-	// Step 2
-	// [pai+1, ... n)
-	for i := pai + 1; i < n; i++ {
-		s, err := participantRandInt(rand, pubs[i], msg)
-		if err != nil {
-			return nil, err
+// checkRing checks that pubs holds at least two valid SM2 public keys.
+func checkRing(pubs []*ecdsa.PublicKey) error {
+	if len(pubs) < 2 {
+		return ErrRingTooSmall
+	}
+	for i, pub := range pubs {
+		if err := checkPublicKey(pub); err != nil {
+			return fmt.Errorf("%w: member %d", err, i)
 		}
-		results[i+1] = s
-		sx, sy := priv.ScalarBaseMult(s.Bytes())
-		c.Add(s, c)
-		c.Mod(c, priv.Params().N)
-		cx, cy := priv.ScalarMult(pubs[i].X, pubs[i].Y, c.Bytes())
-		cx, cy = priv.Add(sx, sy, cx, cy)
-		c = hash(pubs, msg, cx, cy)
-	}
-	results[0] = new(big.Int).Set(c)
-	// [0...pai)
-	for i := 0; i < pai; i++ {
-		s, err := participantRandInt(rand, pubs[i], msg)
-		if err != nil {
-			return nil, err
+	}
+	return nil
+}
+
+// checkPublicKey checks that pub is a valid SM2 public key.
+func checkPublicKey(pub *ecdsa.PublicKey) error {
+	if pub == nil || pub.Curve != sm2.P256() {
+		return ErrNonSM2PublicKey
+	}
+	if !validPoint(pub.X, pub.Y) {
+		return ErrInvalidPublicKey
+	}
+	return nil
+}
+
+func getPai(priv *sm2.PrivateKey, pubs []*ecdsa.PublicKey) (int, error) {
+	if err := checkPrivateKey(priv); err != nil {
+		return -1, err
+	}
+	if err := checkRing(pubs); err != nil {
+		return -1, err
+	}
+	for i := 0; i < len(pubs); i++ {
+		if priv.PublicKey.Equal(pubs[i]) {
+			return i, nil
 		}
-		results[i+1] = s
-		sx, sy := priv.ScalarBaseMult(s.Bytes())
-		c.Add(s, c)
-		c.Mod(c, priv.Params().N)
-		cx, cy := priv.ScalarMult(pubs[i].X, pubs[i].Y, c.Bytes())
-		cx, cy = priv.Add(sx, sy, cx, cy)
-		c = hash(pubs, msg, cx, cy)
 	}
+	return -1, ErrSignerNotInRing
+}
 
-	// Step 3: this step is same with SM2 signature scheme
-	c.Mul(c, priv.D)
-	kPai.Sub(kPai, c)
-	dp1 := new(big.Int).Add(priv.D, one)
+// participantError wraps an error returned by a ParticipantStrategy.
+func participantError(i int, err error) error {
+	return fmt.Errorf("sm2rsign: participant %d: %w", i, err)
+}
 
-	var dp1Inv *big.Int
+// SignRing is like Sign, but signs over a Ring.
+func SignRing(rand io.Reader, participantRandInt ParticipantRandInt, priv *sm2.PrivateKey, ring *Ring, msg []byte) (*RingSignature, error) {
+	if ring == nil {
+		return nil, ErrRingTooSmall
+	}
+	return signRing(context.Background(), rand, participantRandInt, priv, ring, msg)
+}
 
-	if in, ok := priv.Curve.(invertible); ok {
-		dp1Inv = in.Inverse(dp1)
-	} else {
-		dp1Inv = fermatInverse(dp1, priv.Params().N) // N != 0
+// SignRingWithStrategy is like SignRing, but draws the scalars of the other
+// members with strategy, which gets the full signing context.
+func SignRingWithStrategy(rand io.Reader, strategy ParticipantStrategy, priv *sm2.PrivateKey, ring *Ring, msg []byte) (*RingSignature, error) {
+	return SignRingContext(context.Background(), rand, strategy, priv, ring, msg)
+}
+
+// SignRingContext is like SignRingWithStrategy, but stops and returns
+// ctx.Err() if ctx is done before the signature is complete. For large
+// rings, the work which does not depend on the challenges is spread across
+// goroutines; the signature is the same as with SignRingWithStrategy for
+// the same random stream.
+func SignRingContext(ctx context.Context, rand io.Reader, strategy ParticipantStrategy, priv *sm2.PrivateKey, ring *Ring, msg []byte) (*RingSignature, error) {
+	if ring == nil {
+		return nil, ErrRingTooSmall
 	}
+	return signRing(ctx, rand, strategy, priv, ring, msg)
+}
 
-	kPai.Mul(kPai, dp1Inv)
-	kPai.Mod(kPai, priv.Params().N) // N != 0
+// http://www.jcr.cacrnet.org.cn/CN/10.13868/j.cnki.jcr.000472
+func Sign(rand io.Reader, participantRandInt ParticipantRandInt, priv *sm2.PrivateKey, pubs []*ecdsa.PublicKey, msg []byte) (*RingSignature, error) {
+	return signRing(context.Background(), rand, participantRandInt, priv, &Ring{keys: pubs}, msg)
+}
 
-	results[pai+1] = kPai
+func signRing(ctx context.Context, rand io.Reader, strategy ParticipantStrategy, priv *sm2.PrivateKey, ring *Ring, msg []byte) (*RingSignature, error) {
+	pre, err := presignRing(ctx, rand, strategy, priv, ring, msg)
+	if err != nil {
+		return nil, err
+	}
+	return pre.signRing(ctx, msg)
+}
 
-	return results, nil
+// Verify verifies the ring signature over msg against the ring pubs.
+//
+// Malformed input never causes a panic: rings with fewer than two members
+// or with invalid SM2 public keys, nil signatures and scalars outside [0, N)
+// are rejected before any curve arithmetic is performed.
+func Verify(pubs []*ecdsa.PublicKey, msg []byte, signature *RingSignature) bool {
+	return VerifyWithError(pubs, msg, signature) == nil
 }
 
-// fermatInverse calculates the inverse of k in GF(P) using Fermat's method
-// (exponentiation modulo P - 2, per Euler's theorem). This has better
-// constant-time properties than Euclid's method (implemented in
-// math/big.Int.ModInverse and FIPS 186-4, Appendix C.1) although math/big
-// itself isn't strictly constant-time so it's not perfect.
-func fermatInverse(k, N *big.Int) *big.Int {
-	two := big.NewInt(2)
-	nMinus2 := new(big.Int).Sub(N, two)
-	return new(big.Int).Exp(k, nMinus2, N)
+// VerifyWithError is like Verify, but reports why verification failed. The
+// returned error wraps ErrRingTooSmall, ErrInvalidPublicKey,
+// ErrRingSizeMismatch, ErrRingIDMismatch, ErrScalarOutOfRange or
+// ErrRingEquation. The ring ID of the signature is only checked if present.
+//
+// Verify and VerifyWithError can not be cancelled and have no resource
+// limits. For untrusted input, make a Ring with NewRing and use
+// VerifyRingContext or a VerifierPolicy.
+func VerifyWithError(pubs []*ecdsa.PublicKey, msg []byte, signature *RingSignature) error {
+	return verifyRing(context.Background(), &Ring{keys: pubs}, nil, msg, signature)
 }
 
-func Verify(pubs []*ecdsa.PublicKey, msg []byte, signature []*big.Int) bool {
-	if len(pubs)+1 != len(signature) {
-		return false
+// verifyRing verifies signature over ring, using the tables of prepared if
+// it is not nil. It returns ctx.Err() if ctx is done between two steps.
+func verifyRing(ctx context.Context, ring *Ring, prepared *PreparedRing, msg []byte, signature *RingSignature) error {
+	pubs := ring.keys
+	if err := checkRing(pubs); err != nil {
+		return err
+	}
+	if len(pubs) != signature.RingSize() {
+		return ErrRingSizeMismatch
+	}
+	if len(signature.RingID) != 0 && !bytes.Equal(signature.RingID, ring.ID()) {
+		return ErrRingIDMismatch
 	}
+	if err := checkScalars(signature.C, signature.S); err != nil {
+		return err
+	}
+	ch := newChallengeHasher(ring.hashPrefix(), msg)
 
-	c := new(big.Int).Set(signature[0])
+	N := sm2.P256().Params().N
+	c := new(big.Int).Set(signature.C)
 	for i := 0; i < len(pubs); i++ {
-		pub := pubs[i]
-		s := signature[i+1]
-		sx, sy := pub.ScalarBaseMult(s.Bytes())
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s := signature.S[i]
 		c.Add(s, c)
-		c.Mod(c, pub.Params().N)
-		cx, cy := pub.ScalarMult(pubs[i].X, pubs[i].Y, c.Bytes())
-		cx, cy = pub.Add(sx, sy, cx, cy)
-		c = hash(pubs, msg, cx, cy)
+		c.Mod(c, N)
+		c = ch.hash(ringStep(prepared, ring, i, s, c))
 	}
 
-	return c.Cmp(signature[0]) == 0
+	if c.Cmp(signature.C) != 0 {
+		return ErrRingEquation
+	}
+	return nil
+}
+
+// VerifyRing is like Verify, but verifies against a Ring.
+func VerifyRing(ring *Ring, msg []byte, signature *RingSignature) bool {
+	return VerifyRingWithError(ring, msg, signature) == nil
+}
+
+// VerifyRingWithError is like VerifyWithError, but verifies against a Ring.
+func VerifyRingWithError(ring *Ring, msg []byte, signature *RingSignature) error {
+	return VerifyRingContext(context.Background(), ring, msg, signature)
+}
+
+// VerifyRingContext is like VerifyRingWithError, but stops and returns
+// ctx.Err() if ctx is done before the verification is complete. It is
+// checked between the steps of the ring.
+func VerifyRingContext(ctx context.Context, ring *Ring, msg []byte, signature *RingSignature) error {
+	if ring == nil {
+		return ErrRingTooSmall
+	}
+	return verifyRing(ctx, ring, nil, msg, signature)
 }
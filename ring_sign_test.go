@@ -1,8 +1,14 @@
 package sm2rsign
 
 import (
+	"context"
 	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
 	"testing"
 
 	"github.com/emmansun/gmsm/sm2"
@@ -86,3 +92,397 @@ func TestRSignWithThreeKeys(t *testing.T) {
 	testRSignWithThreeKeys(t, SimpleParticipantRandInt)
 	testRSignWithThreeKeys(t, SM2ParticipantRandInt)
 }
+
+func TestVerifyMalformedInput(t *testing.T) {
+	signer, _ := sm2.GenerateKey(rand.Reader)
+	participant, _ := sm2.GenerateKey(rand.Reader)
+	pubs := []*ecdsa.PublicKey{&signer.PublicKey, &participant.PublicKey}
+	msg := []byte("hello world")
+
+	sig, err := Sign(rand.Reader, SimpleParticipantRandInt, signer, pubs, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	N := sm2.P256().Params().N
+	huge := new(big.Int).Lsh(one, 300)
+	oversized := new(big.Int).Lsh(one, 256)
+	tests := []struct {
+		name   string
+		pubs   []*ecdsa.PublicKey
+		mutate func(sig *RingSignature)
+	}{
+		{"nil signature", pubs, nil},
+		{"nil c", pubs, func(sig *RingSignature) { sig.C = nil }},
+		{"nil s", pubs, func(sig *RingSignature) { sig.S[1] = nil }},
+		{"negative c", pubs, func(sig *RingSignature) { sig.C = big.NewInt(-1) }},
+		{"c of 257 bits", pubs, func(sig *RingSignature) { sig.C = oversized }},
+		{"oversized s", pubs, func(sig *RingSignature) { sig.S[0] = huge }},
+		{"s plus N", pubs, func(sig *RingSignature) { sig.S[0] = new(big.Int).Add(sig.S[0], N) }},
+		{"too few s", pubs, func(sig *RingSignature) { sig.S = sig.S[:1] }},
+		{"single member ring", pubs[:1], func(sig *RingSignature) { sig.S = sig.S[:1] }},
+		{"empty ring", nil, func(sig *RingSignature) { sig.S = nil }},
+		{"nil public key", []*ecdsa.PublicKey{pubs[0], nil}, func(sig *RingSignature) {}},
+		{"off-curve public key", []*ecdsa.PublicKey{pubs[0], {Curve: sm2.P256(), X: big.NewInt(1), Y: big.NewInt(2)}}, func(sig *RingSignature) {}},
+		{"infinity public key", []*ecdsa.PublicKey{pubs[0], {Curve: sm2.P256(), X: new(big.Int), Y: new(big.Int)}}, func(sig *RingSignature) {}},
+		{"nil coordinates", []*ecdsa.PublicKey{pubs[0], {Curve: sm2.P256()}}, func(sig *RingSignature) {}},
+	}
+	for _, tt := range tests {
+		var malformed *RingSignature
+		if tt.mutate != nil {
+			malformed = &RingSignature{C: new(big.Int).Set(sig.C), S: append([]*big.Int{}, sig.S...)}
+			tt.mutate(malformed)
+		}
+		if Verify(tt.pubs, msg, malformed) {
+			t.Errorf("%s: verified a malformed signature", tt.name)
+		}
+	}
+	if !Verify(pubs, msg, sig) {
+		t.Errorf("failed to verify the signature")
+	}
+}
+
+func TestVerifyWithError(t *testing.T) {
+	signer, _ := sm2.GenerateKey(rand.Reader)
+	participant, _ := sm2.GenerateKey(rand.Reader)
+	pubs := []*ecdsa.PublicKey{&signer.PublicKey, &participant.PublicKey}
+	msg := []byte("hello world")
+
+	sig, err := Sign(rand.Reader, SimpleParticipantRandInt, signer, pubs, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyWithError(pubs, msg, sig); err != nil {
+		t.Fatal(err)
+	}
+	offCurve := &ecdsa.PublicKey{Curve: sm2.P256(), X: big.NewInt(1), Y: big.NewInt(2)}
+	tests := []struct {
+		name string
+		pubs []*ecdsa.PublicKey
+		msg  []byte
+		sig  *RingSignature
+		err  error
+	}{
+		{"ring too small", pubs[:1], msg, &RingSignature{C: sig.C, S: sig.S[:1]}, ErrRingTooSmall},
+		{"invalid public key", []*ecdsa.PublicKey{pubs[0], offCurve}, msg, sig, ErrInvalidPublicKey},
+		{"ring size mismatch", pubs, msg, &RingSignature{C: sig.C, S: sig.S[:1]}, ErrRingSizeMismatch},
+		{"nil signature", pubs, msg, nil, ErrRingSizeMismatch},
+		{"scalar out of range", pubs, msg, &RingSignature{C: new(big.Int).Lsh(one, 256), S: sig.S}, ErrScalarOutOfRange},
+		// c_0 is not reduced modulo N, see validChallenge
+		{"c equals N", pubs, msg, &RingSignature{C: sm2.P256().Params().N, S: sig.S}, ErrRingEquation},
+		{"ring equation", pubs, []byte("hello"), sig, ErrRingEquation},
+	}
+	for _, tt := range tests {
+		if err := VerifyWithError(tt.pubs, tt.msg, tt.sig); !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestLargeChallenge(t *testing.T) {
+	// force the challenges of every step to be N or larger, which honest
+	// signatures only have with probability about 2^-32
+	toInt := challengeToInt
+	t.Cleanup(func() { challengeToInt = toInt })
+	top := new(big.Int).Lsh(big.NewInt(0xffffffff), 224)
+	challengeToInt = func(digest []byte) *big.Int {
+		c := toInt(digest)
+		return c.Or(c, top)
+	}
+
+	keys, ring := newTestRing(t, 3)
+	msg := []byte("large challenge")
+	N := sm2.P256().Params().N
+	c0 := func(name string, der []byte) *big.Int {
+		if name == "plain" {
+			sig, err := ParseRingSignature(der)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			return sig.C
+		}
+		sig, err := ParseLinkableRingSignature(der)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		return sig.C
+	}
+	ctx := context.Background()
+	strategy := ParticipantRandInt(SimpleParticipantRandInt)
+	for name, sign := range contextSigners(t, keys[1], ring) {
+		// the signature is verified by sign
+		der, err := sign(ctx, rand.Reader, strategy, msg)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		// the challenges of variant 2 are reduced modulo N by definition
+		if name != "variant2" && c0(name, der).Cmp(N) < 0 {
+			t.Errorf("%s: c is below N", name)
+		}
+	}
+	for name, p := range presigners(t, keys[2], ring) {
+		pre, err := p.presign(ctx, rand.Reader, strategy)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		der, err := p.sign(ctx, pre, msg)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if name != "variant2" && c0(name, der).Cmp(N) < 0 {
+			t.Errorf("%s: presigned c is below N", name)
+		}
+	}
+}
+
+type failingReader struct{ err error }
+
+func (r failingReader) Read(p []byte) (int, error) { return 0, r.err }
+
+type participantFailure struct{}
+
+func (e *participantFailure) Error() string { return "participant failure" }
+
+func failingParticipantRandInt(rand io.Reader, pub *ecdsa.PublicKey, msg []byte) (*big.Int, error) {
+	return nil, &participantFailure{}
+}
+
+func testSignErrors(t *testing.T, sign func(priv *sm2.PrivateKey, pubs []*ecdsa.PublicKey, rand io.Reader, participantRandInt ParticipantRandInt) error) {
+	signer, _ := sm2.GenerateKey(rand.Reader)
+	participant, _ := sm2.GenerateKey(rand.Reader)
+	outsider, _ := sm2.GenerateKey(rand.Reader)
+	nistKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	pubs := []*ecdsa.PublicKey{&participant.PublicKey, &signer.PublicKey}
+
+	withD := func(d *big.Int) *sm2.PrivateKey {
+		return &sm2.PrivateKey{PrivateKey: ecdsa.PrivateKey{PublicKey: signer.PublicKey, D: d}}
+	}
+
+	errRead := errors.New("entropy exhausted")
+	tests := []struct {
+		name string
+		priv *sm2.PrivateKey
+		pubs []*ecdsa.PublicKey
+		rand io.Reader
+		err  error
+	}{
+		{"nil private key", nil, pubs, rand.Reader, ErrInvalidPrivateKey},
+		{"zero private key", withD(new(big.Int)), pubs, rand.Reader, ErrInvalidPrivateKey},
+		{"N-1 private key", withD(new(big.Int).Sub(sm2.P256().Params().N, one)), pubs, rand.Reader, ErrInvalidPrivateKey},
+		{"mismatched public key", withD(outsider.D), pubs, rand.Reader, ErrInvalidPrivateKey},
+		{"ring too small", signer, pubs[1:], rand.Reader, ErrRingTooSmall},
+		{"non SM2 public key", signer, []*ecdsa.PublicKey{&nistKey.PublicKey, &signer.PublicKey}, rand.Reader, ErrNonSM2PublicKey},
+		{"nil public key", signer, []*ecdsa.PublicKey{nil, &signer.PublicKey}, rand.Reader, ErrNonSM2PublicKey},
+		{"signer not in ring", signer, []*ecdsa.PublicKey{&participant.PublicKey, &outsider.PublicKey}, rand.Reader, ErrSignerNotInRing},
+		{"random source", signer, pubs, failingReader{errRead}, ErrRandomSource},
+		{"random source cause", signer, pubs, failingReader{errRead}, errRead},
+	}
+	for _, tt := range tests {
+		if err := sign(tt.priv, tt.pubs, tt.rand, SimpleParticipantRandInt); !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+
+	var failure *participantFailure
+	if err := sign(signer, pubs, rand.Reader, failingParticipantRandInt); !errors.As(err, &failure) {
+		t.Errorf("participant failure: got %v", err)
+	}
+}
+
+func TestSignErrors(t *testing.T) {
+	testSignErrors(t, func(priv *sm2.PrivateKey, pubs []*ecdsa.PublicKey, rand io.Reader, participantRandInt ParticipantRandInt) error {
+		_, err := Sign(rand, participantRandInt, priv, pubs, []byte("hello world"))
+		return err
+	})
+}
+
+// legacyKeys are the private keys of the ring of legacySignatures.
+var legacyKeys = []string{
+	"6c5a0a0b2eed3cbec3e4f1252bfe0e28c504a1c6bf1999eeb4b9c4e7d6a1d7f1",
+	"3945208f7b2144b13f36e38ac6d39f95889393692860b51a42fb81ef4df7c5b8",
+	"1649ab77a00637bd5e2efe283fbf353534aa7f7cb89463f208ddbc2920bb0da0",
+}
+
+// legacySignatures were made by the second member of the ring of legacyKeys
+// with the first release, which rehashed the ring at every step, over the
+// message "legacy signature". The linkable signatures are [Qx, Qy, c, s...].
+var legacySignatures = map[string][]string{
+	"plain": {
+		"728a08991b7a99dd67f6074ecc8573c31ef47c3dbc1fcc3d55a0bbbd2148669a",
+		"40702719246aaf1c3ef2ebdf7b1840705185261651cd7012e517bfe143e25afc",
+		"6a37689bd912db475f04a26452d974c41cbf57b94e34714298f78fccd92bea0d",
+		"c38d1d6246739ee944aada9a8d2f85bc19a0bbc601036a0d62bbf17a1cab7ab7",
+	},
+	"base": {
+		"96355ed585f93e1a947bb769d12a510231b65025c463977178d5a6b714713ab4",
+		"9e45e13d6af2adcb501ca934b0d4fa3cc9be6b1e0005d347213de4473f79409c",
+		"87dad72feb7722179c0515b9660d1ecde8f4f9ee7bd084b75a868c1ae5a056dd",
+		"414ef670e7307fe6bdf41d6068c2ea5baca1802827ea4b5343f7b07b4e9648f1",
+		"c7c30fe3688ea6ea00131c44d0b58159fa192f7eb00133794a5873878d4d9149",
+		"1b6da74549ef919479530b9f0d99571fe2993481c9ef9fff80d10b16ca3cad71",
+	},
+	"variant1": {
+		"96355ed585f93e1a947bb769d12a510231b65025c463977178d5a6b714713ab4",
+		"9e45e13d6af2adcb501ca934b0d4fa3cc9be6b1e0005d347213de4473f79409c",
+		"e20f31acaa5521428937ba106918f22d5c738c05ab245659310242f90b1515ce",
+		"cebdb4aad100dbb48c4134ca4ffb314d1138a33b590dc5e2b685384f96259657",
+		"e471e424b77f40280579e4afe9f25ce4c2b913bb1530daa40967c51e141b0115",
+		"db592d6675d13fae2c6df4a16d69c0af5f1a3eff59e2411781b74fbf7935d50e",
+	},
+	"variant2": {
+		"96355ed585f93e1a947bb769d12a510231b65025c463977178d5a6b714713ab4",
+		"9e45e13d6af2adcb501ca934b0d4fa3cc9be6b1e0005d347213de4473f79409c",
+		"7188e7e7179e3a68c513fea3d340540329adb4ff387c903fad55296716f449af",
+		"c318c17134c9f75e6ea9021c0c53d81b86a3551c63fd19968c1b708f8f542f18",
+		"63c24933bfa04bee1b6ddc9eda3d8d9874ca6d119abf115237281f4b29233cc0",
+		"1ee121abfa5ea1cd9e1ca9730f453870cc36743c157d7ec936de9d473a1d1224",
+	},
+}
+
+func legacySignature(t *testing.T, name string) []*big.Int {
+	t.Helper()
+	var sig []*big.Int
+	for _, s := range legacySignatures[name] {
+		v, ok := new(big.Int).SetString(s, 16)
+		if !ok {
+			t.Fatalf("bad scalar %q", s)
+		}
+		sig = append(sig, v)
+	}
+	return sig
+}
+
+func legacyRing(t *testing.T) []*ecdsa.PublicKey {
+	t.Helper()
+	var pubs []*ecdsa.PublicKey
+	for _, d := range legacyKeys {
+		v, _ := new(big.Int).SetString(d, 16)
+		key, err := sm2.NewPrivateKeyFromInt(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubs = append(pubs, &key.PublicKey)
+	}
+	return pubs
+}
+
+// TestLegacySignatures checks that signatures made before the ring and the
+// message were absorbed only once still verify.
+func TestLegacySignatures(t *testing.T) {
+	pubs := legacyRing(t)
+	msg := []byte("legacy signature")
+	sig, err := NewRingSignature(legacySignature(t, "plain"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyWithError(pubs, msg, sig); err != nil {
+		t.Errorf("plain: %v", err)
+	}
+	if Verify(pubs, []byte("another message"), sig) {
+		t.Errorf("plain: verified another message")
+	}
+
+	tests := []struct {
+		scheme   LinkableScheme
+		name     string
+		verifier RingVerifier
+	}{
+		{LinkableSchemeBase, "base", NewBaseLinkableVerfier(pubs)},
+		{LinkableSchemeVariant1, "variant1", NewLinkableVerfierVariant1(pubs)},
+		{LinkableSchemeVariant2, "variant2", NewLinkableVerfierVariant2(pubs)},
+	}
+	for _, tt := range tests {
+		sig, err := NewLinkableRingSignature(tt.scheme, legacySignature(t, tt.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !tt.verifier.Verify(msg, sig) {
+			t.Errorf("%s: failed to verify", tt.name)
+		}
+		if tt.verifier.Verify([]byte("another message"), sig) {
+			t.Errorf("%s: verified another message", tt.name)
+		}
+	}
+
+	// the first NewLinkableSignerVariant2 signed with variant 1: its
+	// signatures fail as variant 2 and verify as variant 1
+	sig2, err := NewLinkableRingSignature(LinkableSchemeVariant2, legacySignature(t, "variant1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewLinkableVerfierVariant2(pubs).VerifyWithError(msg, sig2); !errors.Is(err, ErrRingEquation) {
+		t.Errorf("variant 1 signature as variant 2: got %v, want ErrRingEquation", err)
+	}
+	sig1, err := NewLinkableRingSignature(LinkableSchemeVariant1, legacySignature(t, "variant1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewLinkableVerfierVariant1(pubs).VerifyWithError(msg, sig1); err != nil {
+		t.Errorf("migrated variant 2 signature: %v", err)
+	}
+}
+
+// legacyRingStep is the step arithmetic of the first release, which
+// BenchmarkRingStep compares ringStep against.
+func legacyRingStep(pub *ecdsa.PublicKey, s, c *big.Int) (x, y *big.Int) {
+	sx, sy := pub.ScalarBaseMult(s.Bytes())
+	cx, cy := pub.ScalarMult(pub.X, pub.Y, c.Bytes())
+	return pub.Add(sx, sy, cx, cy)
+}
+
+func TestRingStep(t *testing.T) {
+	_, ring := newTestRing(t, 2)
+	pr, err := NewPreparedRing(ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := randFieldElement(sm2.P256(), rand.Reader)
+	c, _ := randFieldElement(sm2.P256(), rand.Reader)
+	wantX, wantY := legacyRingStep(ring.PublicKey(1), s, c)
+	for _, prepared := range []*PreparedRing{nil, pr} {
+		x, y := ringStep(prepared, ring, 1, s, c)
+		if x.Cmp(wantX) != 0 || y.Cmp(wantY) != 0 {
+			t.Errorf("prepared %v: step mismatch", prepared != nil)
+		}
+	}
+}
+
+// BenchmarkRingStep measures the cost of a ring member in verification.
+func BenchmarkRingStep(b *testing.B) {
+	_, ring := newTestRing(b, 2)
+	pr, err := NewPreparedRing(ring)
+	if err != nil {
+		b.Fatal(err)
+	}
+	s, _ := randFieldElement(sm2.P256(), rand.Reader)
+	c, _ := randFieldElement(sm2.P256(), rand.Reader)
+	pub := ring.PublicKey(1)
+	b.Run("Legacy", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			legacyRingStep(pub, s, c)
+		}
+	})
+	b.Run("Default", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			ringStep(nil, ring, 1, s, c)
+		}
+	})
+	b.Run("Prepared", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			ringStep(pr, ring, 1, s, c)
+		}
+	})
+}
+
+func BenchmarkSign(b *testing.B) {
+	msg := []byte("hello world")
+	for _, n := range []int{2, 8, 32} {
+		keys, ring := newTestRing(b, n)
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := SignRing(rand.Reader, SimpleParticipantRandInt, keys[0], ring, msg); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package sm2rsign
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	mathrand "math/rand/v2"
+	"testing"
+
+	"github.com/emmansun/gmsm/sm2"
+)
+
+func TestNewRingErrors(t *testing.T) {
+	key1, _ := sm2.GenerateKey(rand.Reader)
+	key2, _ := sm2.GenerateKey(rand.Reader)
+	nistKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	offCurve := &ecdsa.PublicKey{Curve: sm2.P256(), X: big.NewInt(1), Y: big.NewInt(2)}
+	duplicate := &ecdsa.PublicKey{Curve: sm2.P256(), X: new(big.Int).Set(key1.X), Y: new(big.Int).Set(key1.Y)}
+
+	tests := []struct {
+		name string
+		pubs []*ecdsa.PublicKey
+		err  error
+	}{
+		{"empty", nil, ErrRingTooSmall},
+		{"single member", []*ecdsa.PublicKey{&key1.PublicKey}, ErrRingTooSmall},
+		{"non SM2 key", []*ecdsa.PublicKey{&key1.PublicKey, &nistKey.PublicKey}, ErrNonSM2PublicKey},
+		{"nil key", []*ecdsa.PublicKey{&key1.PublicKey, nil}, ErrNonSM2PublicKey},
+		{"off-curve key", []*ecdsa.PublicKey{&key1.PublicKey, offCurve}, ErrInvalidPublicKey},
+		{"duplicate key", []*ecdsa.PublicKey{&key1.PublicKey, &key2.PublicKey, duplicate}, ErrDuplicatePublicKey},
+	}
+	for _, tt := range tests {
+		if _, err := NewRing(tt.pubs); !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+		if _, err := NewRing(tt.pubs, WithCanonicalOrder()); !errors.Is(err, tt.err) {
+			t.Errorf("%s: canonical: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestRingDefensiveCopy(t *testing.T) {
+	key1, _ := sm2.GenerateKey(rand.Reader)
+	key2, _ := sm2.GenerateKey(rand.Reader)
+	key3, _ := sm2.GenerateKey(rand.Reader)
+	pub1 := &ecdsa.PublicKey{Curve: sm2.P256(), X: new(big.Int).Set(key1.X), Y: new(big.Int).Set(key1.Y)}
+	pubs := []*ecdsa.PublicKey{pub1, &key2.PublicKey}
+
+	ring, err := NewRing(pubs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubs[1] = &key3.PublicKey
+	pub1.X.Add(pub1.X, one)
+	if ring.Index(&key1.PublicKey) != 0 || ring.Index(&key2.PublicKey) != 1 || ring.Index(&key3.PublicKey) != -1 {
+		t.Errorf("ring changed after its source keys were modified")
+	}
+
+	members := ring.PublicKeys()
+	members[0].X.SetInt64(1)
+	members[1] = &key3.PublicKey
+	if !ring.PublicKey(0).Equal(&key1.PublicKey) || !ring.PublicKey(1).Equal(&key2.PublicKey) || ring.Len() != 2 {
+		t.Errorf("ring changed after its members were modified")
+	}
+}
+
+func TestRingCanonicalOrder(t *testing.T) {
+	keys := make([]*ecdsa.PublicKey, 5)
+	for i := range keys {
+		key, _ := sm2.GenerateKey(rand.Reader)
+		keys[i] = &key.PublicKey
+	}
+	reversed := make([]*ecdsa.PublicKey, len(keys))
+	for i, pub := range keys {
+		reversed[len(keys)-1-i] = pub
+	}
+	ring1, err := NewRing(keys, WithCanonicalOrder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring2, err := NewRing(reversed, WithCanonicalOrder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < ring1.Len(); i++ {
+		if !ring1.PublicKey(i).Equal(ring2.PublicKey(i)) {
+			t.Fatalf("canonical order depends on input order")
+		}
+		if i > 0 {
+			prev := ring1.PublicKey(i - 1)
+			cur := ring1.PublicKey(i)
+			if bytes.Compare(elliptic.MarshalCompressed(prev.Curve, prev.X, prev.Y), elliptic.MarshalCompressed(cur.Curve, cur.X, cur.Y)) >= 0 {
+				t.Fatalf("members are not sorted")
+			}
+		}
+	}
+
+	signer, _ := sm2.GenerateKey(rand.Reader)
+	msg := []byte("hello world")
+	signerRing, _ := NewRing(append([]*ecdsa.PublicKey{&signer.PublicKey}, keys...), WithCanonicalOrder())
+	verifierRing, _ := NewRing(append(append([]*ecdsa.PublicKey{}, reversed...), &signer.PublicKey), WithCanonicalOrder())
+	sig, err := SignRing(rand.Reader, SimpleParticipantRandInt, signer, signerRing, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyRingWithError(verifierRing, msg, sig); err != nil {
+		t.Errorf("failed to verify over an equivalent canonical ring: %v", err)
+	}
+}
+
+func TestLinkableWithRing(t *testing.T) {
+	signer, _ := sm2.GenerateKey(rand.Reader)
+	participant, _ := sm2.GenerateKey(rand.Reader)
+	ring, err := NewRing([]*ecdsa.PublicKey{&participant.PublicKey, &signer.PublicKey}, WithCanonicalOrder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("hello world")
+
+	tests := []struct {
+		signer   RingSigner
+		verifier RingVerifier
+	}{
+		{NewBaseLinkableSignerWithRing(signer, ring), NewBaseLinkableVerfierWithRing(ring)},
+		{NewLinkableSignerVariant1WithRing(signer, ring), NewLinkableVerfierVariant1WithRing(ring)},
+		{NewLinkableSignerVariant2WithRing(signer, ring), NewLinkableVerfierVariant2WithRing(ring)},
+		{NewScopedLinkableSignerWithRing(signer, ring, []byte("scope")), NewScopedLinkableVerfierWithRing(ring, []byte("scope"))},
+	}
+	for _, tt := range tests {
+		sig, err := tt.signer.Sign(rand.Reader, SimpleParticipantRandInt, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !tt.verifier.Verify(msg, sig) {
+			t.Errorf("%v: failed to verify the signature", sig.Scheme)
+		}
+	}
+
+	if _, err := NewBaseLinkableSignerWithRing(signer, nil).Sign(rand.Reader, SimpleParticipantRandInt, msg); !errors.Is(err, ErrRingTooSmall) {
+		t.Errorf("nil ring: got %v", err)
+	}
+	if NewBaseLinkableVerfierWithRing(nil).Verify(msg, nil) || VerifyRing(nil, msg, nil) {
+		t.Errorf("verified over a nil ring")
+	}
+}
+
+func TestSignerCopiesPublicKeys(t *testing.T) {
+	signer, _ := sm2.GenerateKey(rand.Reader)
+	participant, _ := sm2.GenerateKey(rand.Reader)
+	outsider, _ := sm2.GenerateKey(rand.Reader)
+	pubs := []*ecdsa.PublicKey{&signer.PublicKey, &participant.PublicKey}
+	msg := []byte("hello world")
+
+	baseSigner := NewBaseLinkableSigner(signer, pubs)
+	pubs[1] = &outsider.PublicKey
+	sig, err := baseSigner.Sign(rand.Reader, SimpleParticipantRandInt, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !NewBaseLinkableVerfier([]*ecdsa.PublicKey{&signer.PublicKey, &participant.PublicKey}).Verify(msg, sig) {
+		t.Errorf("signer used the modified ring")
+	}
+}
+
+func TestRingMembers(t *testing.T) {
+	keys, ring := newTestRing(t, 3)
+	uids := [][]byte{[]byte("alice@example.com"), nil, []byte("carol@example.com")}
+	members := make([]RingMember, len(keys))
+	for i, key := range keys {
+		members[i] = RingMember{PublicKey: &key.PublicKey, UID: uids[i]}
+	}
+
+	// the user IDs follow their keys
+	sorted, err := NewRingWithMembers(members, WithCanonicalOrder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range keys {
+		want := uids[i]
+		if want == nil {
+			want = defaultUID
+		}
+		if j := sorted.Index(&key.PublicKey); !bytes.Equal(sorted.UID(j), want) {
+			t.Errorf("member %d: got user ID %q, want %q", i, sorted.UID(j), want)
+		}
+	}
+
+	withUIDs, err := NewRingWithMembers(members)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(withUIDs.ID(), ring.ID()) {
+		t.Errorf("user IDs changed the ring ID without WithZABinding")
+	}
+	bound, err := NewRingWithMembers(members, WithZABinding())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(bound.ID(), ring.ID()) {
+		t.Errorf("user IDs did not change the ring ID with WithZABinding")
+	}
+
+	// the ZA values are part of the challenge hashes
+	msg := []byte("hello world")
+	sig, err := SignRingWithStrategy(rand.Reader, SM2UIDParticipants(nil), keys[1], bound, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyRingWithError(bound, msg, sig); err != nil {
+		t.Error(err)
+	}
+	members[0].UID = []byte("mallory@example.com")
+	other, err := NewRingWithMembers(members, WithZABinding())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyRingWithError(other, msg, sig); !errors.Is(err, ErrRingIDMismatch) {
+		t.Errorf("got %v, want %v", err, ErrRingIDMismatch)
+	}
+	sig.RingID = nil
+	if err := VerifyRingWithError(other, msg, sig); !errors.Is(err, ErrRingEquation) {
+		t.Errorf("got %v, want %v", err, ErrRingEquation)
+	}
+	lsig, err := NewBaseLinkableSignerWithRing(keys[1], bound).Sign(rand.Reader, SimpleParticipantRandInt, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewBaseLinkableVerfierWithRing(bound).VerifyWithError(msg, lsig); err != nil {
+		t.Error(err)
+	}
+
+	// SM2UIDParticipants uses the user IDs of the ring
+	uid := func(pub *ecdsa.PublicKey) []byte {
+		return withUIDs.UID(withUIDs.Index(pub))
+	}
+	sig1, _ := SignRingWithStrategy(mathrand.NewChaCha8([32]byte{}), SM2UIDParticipants(nil), keys[1], withUIDs, msg)
+	sig2, _ := SignRingWithStrategy(mathrand.NewChaCha8([32]byte{}), SM2UIDParticipants(uid), keys[1], withUIDs, msg)
+	sig3, _ := SignRingWithStrategy(mathrand.NewChaCha8([32]byte{}), ParticipantRandInt(SM2ParticipantRandInt), keys[1], withUIDs, msg)
+	if sig1.S[0].Cmp(sig2.S[0]) != 0 || sig1.S[0].Cmp(sig3.S[0]) == 0 {
+		t.Errorf("the user IDs of the ring were not used")
+	}
+
+	members[0].UID = make([]byte, maxUIDLen+1)
+	if _, err := NewRingWithMembers(members); !errors.Is(err, ErrInvalidUID) {
+		t.Errorf("got %v, want %v", err, ErrInvalidUID)
+	}
+}
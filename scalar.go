@@ -0,0 +1,177 @@
+package sm2rsign
+
+import (
+	cryptorand "crypto/rand"
+	"fmt"
+	"io"
+	"math/big"
+	"math/bits"
+
+	"github.com/emmansun/gmsm/sm2"
+)
+
+// scalar is an element of the scalar field GF(N) of the SM2 curve in the
+// Montgomery domain, i.e. x·R mod N with R = 2^256, as four little-endian
+// 64-bit limbs. All operations are constant time, it holds the secret
+// values of the signer: the private key d and the nonce k.
+type scalar [4]uint64
+
+// p256N is the order N of the SM2 curve.
+var p256N = [4]uint64{0x53bbf40939d54123, 0x7203df6b21c6052b, 0xffffffffffffffff, 0xfffffffeffffffff}
+
+// p256NInv is -N^-1 mod 2^64.
+const p256NInv = 0x327f9e8872350975
+
+var (
+	// scOne is 1 in the Montgomery domain, R mod N.
+	scOne = scalar{0xac440bf6c62abedd, 0x8dfc2094de39fad4, 0x0000000000000000, 0x0000000100000000}
+	// scRR is R^2 mod N, used to convert into the Montgomery domain.
+	scRR = scalar{0x901192af7c114f20, 0x3464504ade6fa2fa, 0x620fc84c3affe0d4, 0x1eb5e412a22b3d3b}
+	// nMinus2 is the big-endian exponent of the inversion.
+	nMinus2 = new(big.Int).Sub(sm2.P256().Params().N, big.NewInt(2)).Bytes()
+)
+
+func (z *scalar) Mul(x, y *scalar) *scalar {
+	montMul((*[4]uint64)(z), (*[4]uint64)(x), (*[4]uint64)(y), &p256N, p256NInv)
+	return z
+}
+
+func (z *scalar) Add(x, y *scalar) *scalar {
+	modAdd((*[4]uint64)(z), (*[4]uint64)(x), (*[4]uint64)(y), &p256N)
+	return z
+}
+
+func (z *scalar) Sub(x, y *scalar) *scalar {
+	modSub((*[4]uint64)(z), (*[4]uint64)(x), (*[4]uint64)(y), &p256N)
+	return z
+}
+
+// IsZero returns 1 if z = 0, and 0 otherwise.
+func (z *scalar) IsZero() int {
+	return (*fieldElement)(z).IsZero()
+}
+
+// Invert sets z = x^-1 = x^(N-2) mod N, or 0 if x = 0. The square and
+// multiply chain only depends on the public exponent.
+func (z *scalar) Invert(x *scalar) *scalar {
+	r := scOne
+	for _, b := range nMinus2 {
+		for i := 7; i >= 0; i-- {
+			r.Mul(&r, &r)
+			if b>>i&1 == 1 {
+				r.Mul(&r, x)
+			}
+		}
+	}
+	*z = r
+	return z
+}
+
+// SetBytes sets z to the 32-byte big-endian value b, which must be < N. It
+// returns false otherwise.
+func (z *scalar) SetBytes(b []byte) bool {
+	var v [4]uint64
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 8; j++ {
+			v[3-i] = v[3-i]<<8 | uint64(b[8*i+j])
+		}
+	}
+	_, borrow := bits.Sub64(v[0], p256N[0], 0)
+	_, borrow = bits.Sub64(v[1], p256N[1], borrow)
+	_, borrow = bits.Sub64(v[2], p256N[2], borrow)
+	_, borrow = bits.Sub64(v[3], p256N[3], borrow)
+	if borrow == 0 {
+		return false
+	}
+	s := scalar(v)
+	z.Mul(&s, &scRR)
+	clear(v[:])
+	clear(s[:])
+	return true
+}
+
+// SetBig sets z to x, which must be in [0, N). It returns false otherwise.
+func (z *scalar) SetBig(x *big.Int) bool {
+	if x.Sign() < 0 || x.BitLen() > 256 {
+		return false
+	}
+	var b [32]byte
+	defer clear(b[:])
+	return z.SetBytes(x.FillBytes(b[:]))
+}
+
+// FillBytes writes the 32-byte big-endian encoding of z into b.
+func (z *scalar) FillBytes(b []byte) []byte {
+	one := scalar{1}
+	var v scalar
+	v.Mul(z, &one)
+	defer clear(v[:])
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 8; j++ {
+			b[8*i+j] = byte(v[3-i] >> (56 - 8*j))
+		}
+	}
+	return b
+}
+
+// Big returns z as a big.Int.
+func (z *scalar) Big() *big.Int {
+	var b [32]byte
+	defer clear(b[:])
+	return new(big.Int).SetBytes(z.FillBytes(b[:]))
+}
+
+// randScalar sets z to a uniformly random non-zero scalar read from rand.
+func randScalar(z *scalar, rand io.Reader) error {
+	var b [32]byte
+	defer clear(b[:])
+	for {
+		if _, err := io.ReadFull(rand, b[:]); err != nil {
+			return fmt.Errorf("%w: %w", ErrRandomSource, err)
+		}
+		if z.SetBytes(b[:]) && z.IsZero() == 0 {
+			return nil
+		}
+	}
+}
+
+// zeroBig overwrites the words of the secret k with zeros and sets k to 0.
+func zeroBig(k *big.Int) {
+	if k != nil {
+		clear(k.Bits())
+		k.SetInt64(0)
+	}
+}
+
+// signerResponse returns the response s = (k - c·d)·(1 + d)^-1 mod N of the
+// signer's step, where k and d are secret, in constant time. Both the
+// numerator and 1 + d are multiplied by a fresh random blinding factor, so
+// the inversion never works on a value derived from d alone. The blinding
+// factor is read from crypto/rand and does not change the result, so
+// signatures only depend on the random source of the caller. The challenge c
+// is public and may be N or larger, see validChallenge.
+func signerResponse(k, c, d *big.Int) (*big.Int, error) {
+	var ks, cs, ds, b, num, den scalar
+	defer func() {
+		for _, v := range []*scalar{&ks, &cs, &ds, &b, &num, &den} {
+			clear(v[:])
+		}
+	}()
+	if err := randScalar(&b, cryptorand.Reader); err != nil {
+		return nil, err
+	}
+	// c reduced modulo N is always in range
+	cs.SetBig(new(big.Int).Mod(c, sm2.P256().Params().N))
+	if !ks.SetBig(k) || !ds.SetBig(d) {
+		return nil, ErrInvalidPrivateKey
+	}
+	// num = (k - c·d)·b
+	num.Mul(&cs, &ds)
+	num.Sub(&ks, &num)
+	num.Mul(&num, &b)
+	// den = ((1 + d)·b)^-1
+	den.Add(&ds, &scOne)
+	den.Mul(&den, &b)
+	den.Invert(&den)
+	return num.Mul(&num, &den).Big(), nil
+}
@@ -0,0 +1,102 @@
+package sm2rsign
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/emmansun/gmsm/sm2"
+)
+
+func TestScopedLinkableAcrossRings(t *testing.T) {
+	signer, _ := sm2.GenerateKey(rand.Reader)
+	participant1, _ := sm2.GenerateKey(rand.Reader)
+	participant2, _ := sm2.GenerateKey(rand.Reader)
+	participant3, _ := sm2.GenerateKey(rand.Reader)
+	ring1 := []*ecdsa.PublicKey{&signer.PublicKey, &participant1.PublicKey}
+	ring2 := []*ecdsa.PublicKey{&participant2.PublicKey, &signer.PublicKey, &participant3.PublicKey}
+	scope := []byte("election-2026")
+	msg1 := []byte("vote for A")
+	msg2 := []byte("vote for B")
+
+	signer1 := NewScopedLinkableSigner(signer, ring1, scope)
+	sig1, err := signer1.Sign(rand.Reader, SimpleParticipantRandInt, msg1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig1.Scheme != LinkableSchemeScoped {
+		t.Errorf("got scheme %v", sig1.Scheme)
+	}
+	sig2, err := NewScopedLinkableSigner(signer, ring2, scope).Sign(rand.Reader, SM2ParticipantRandInt, msg2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !NewScopedLinkableVerfier(ring1, scope).Verify(msg1, sig1) {
+		t.Errorf("failed to verify the first signature")
+	}
+	if !NewScopedLinkableVerfier(ring2, scope).Verify(msg2, sig2) {
+		t.Errorf("failed to verify the second signature")
+	}
+	if !Linkable(sig1, sig2) {
+		t.Errorf("failed to link signatures over different rings in the same scope")
+	}
+
+	sig3, err := NewScopedLinkableSigner(signer, ring1, []byte("election-2027")).Sign(rand.Reader, SimpleParticipantRandInt, msg1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Linkable(sig1, sig3) {
+		t.Errorf("linked signatures in different scopes")
+	}
+	if NewScopedLinkableVerfier(ring1, []byte("election-2027")).Verify(msg1, sig1) {
+		t.Errorf("verified a signature in another scope")
+	}
+
+	other, err := NewScopedLinkableSigner(participant1, ring1, scope).Sign(rand.Reader, SimpleParticipantRandInt, msg1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Linkable(sig1, other) {
+		t.Errorf("linked signatures of different signers")
+	}
+}
+
+func TestScopedLinkableSchemeSeparation(t *testing.T) {
+	signer, _ := sm2.GenerateKey(rand.Reader)
+	participant, _ := sm2.GenerateKey(rand.Reader)
+	pubs := []*ecdsa.PublicKey{&signer.PublicKey, &participant.PublicKey}
+	scope := []byte("epoch-42")
+	msg := []byte("hello world")
+
+	scoped := NewScopedLinkableSigner(signer, pubs, scope)
+	if string(scoped.Scope()) != string(scope) {
+		t.Errorf("unexpected scope")
+	}
+	sig, err := scoped.Sign(rand.Reader, SimpleParticipantRandInt, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewBaseLinkableVerfier(pubs).VerifyWithError(msg, sig); !errors.Is(err, ErrSchemeMismatch) {
+		t.Errorf("base verifier: got %v", err)
+	}
+
+	der, err := sig.MarshalASN1()
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := NewScopedLinkableVerfier(pubs, scope)
+	if !verifier.VerifyASN1(msg, der) {
+		t.Errorf("failed to verify the ASN.1 encoded signature")
+	}
+	if NewBaseLinkableVerfier(pubs).VerifyASN1(msg, der) {
+		t.Errorf("base verifier accepted a scoped signature")
+	}
+	baseSig, err := NewBaseLinkableSigner(signer, pubs).Sign(rand.Reader, SimpleParticipantRandInt, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verifier.Verify(msg, baseSig) {
+		t.Errorf("scoped verifier accepted a base signature")
+	}
+}
@@ -0,0 +1,428 @@
+package sm2rsign
+
+import (
+	"crypto/elliptic"
+	"errors"
+	"math/big"
+
+	"github.com/emmansun/gmsm/sm2"
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/cryptobyte/asn1"
+)
+
+var (
+	errInvalidASN1   = errors.New("sm2rsign: invalid ASN.1 ring signature")
+	errInvalidBinary = errors.New("sm2rsign: invalid binary ring signature")
+)
+
+const (
+	// scalarSize is the size of c and each s_i in the compact binary format.
+	scalarSize = 32
+	// compressedPointSize is the size of the key image in the compact binary
+	// format.
+	compressedPointSize = 1 + scalarSize
+)
+
+// RingSignature is a plain (non-linkable) ring signature produced by Sign.
+//
+// Its ASN.1 DER encoding is:
+//
+//	RingSignature ::= SEQUENCE {
+//	    c       INTEGER,
+//	    s       SEQUENCE OF INTEGER,  -- s_1, ..., s_n in ring order
+//	    ringID  [0] IMPLICIT OCTET STRING OPTIONAL
+//	}
+type RingSignature struct {
+	C *big.Int
+	S []*big.Int
+	// RingID identifies the ring the signature was made over, see RingID.
+	// It is optional: the legacy slice form and the compact binary format
+	// do not carry it.
+	RingID []byte
+}
+
+// NewRingSignature converts the legacy slice form {c, s_1, ..., s_n} into a
+// RingSignature. See reduceLegacyScalars for how s_i are normalized.
+func NewRingSignature(signature []*big.Int) (*RingSignature, error) {
+	if len(signature) < 2 {
+		return nil, errors.New("sm2rsign: ring signature is too short")
+	}
+	return &RingSignature{C: signature[0], S: reduceLegacyScalars(signature[1:])}, nil
+}
+
+// reduceLegacyScalars returns a copy of s in which every value in [N, 2N) is
+// reduced modulo N. Earlier versions of SM2ParticipantRandInt did not reduce
+// r + k, and the verification equations only use s_i modulo N, so this keeps
+// such stored signatures verifying under the strict range checks of Verify.
+// Other values are kept as is, without copying the big.Int.
+func reduceLegacyScalars(s []*big.Int) []*big.Int {
+	N := sm2.P256().Params().N
+	twoN := new(big.Int).Lsh(N, 1)
+	results := make([]*big.Int, len(s))
+	for i, k := range s {
+		results[i] = k
+		if k != nil && k.Cmp(N) >= 0 && k.Cmp(twoN) < 0 {
+			results[i] = new(big.Int).Sub(k, N)
+		}
+	}
+	return results
+}
+
+// RingSize returns the number of ring members the signature was made for.
+func (sig *RingSignature) RingSize() int {
+	if sig == nil {
+		return 0
+	}
+	return len(sig.S)
+}
+
+// Slice returns the legacy slice form {c, s_1, ..., s_n}.
+func (sig *RingSignature) Slice() []*big.Int {
+	results := make([]*big.Int, 0, len(sig.S)+1)
+	results = append(results, sig.C)
+	return append(results, sig.S...)
+}
+
+// MarshalASN1 returns the ASN.1 DER encoding of the signature.
+func (sig *RingSignature) MarshalASN1() ([]byte, error) {
+	var b cryptobyte.Builder
+	b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		addASN1Int(b, sig.C)
+		b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			for _, s := range sig.S {
+				addASN1Int(b, s)
+			}
+		})
+		addRingID(b, sig.RingID)
+	})
+	return b.Bytes()
+}
+
+// ParseRingSignature parses an ASN.1 DER encoded RingSignature. Non-minimal
+// encodings, negative integers and trailing data are rejected.
+func ParseRingSignature(der []byte) (*RingSignature, error) {
+	var inner, values cryptobyte.String
+	input := cryptobyte.String(der)
+	sig := &RingSignature{C: new(big.Int)}
+	if !input.ReadASN1(&inner, asn1.SEQUENCE) ||
+		!input.Empty() ||
+		!readASN1Int(&inner, sig.C) ||
+		!inner.ReadASN1(&values, asn1.SEQUENCE) ||
+		!readRingID(&inner, &sig.RingID) ||
+		!inner.Empty() {
+		return nil, errInvalidASN1
+	}
+	for !values.Empty() {
+		s := new(big.Int)
+		if !readASN1Int(&values, s) {
+			return nil, errInvalidASN1
+		}
+		sig.S = append(sig.S, s)
+	}
+	if len(sig.S) == 0 {
+		return nil, errInvalidASN1
+	}
+	return sig, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler with the compact
+// fixed-width format c || s_1 || ... || s_n, where every value is a 32-byte
+// big-endian scalar. The ring size is implied by the length.
+func (sig *RingSignature) MarshalBinary() ([]byte, error) {
+	out := make([]byte, scalarSize*(len(sig.S)+1))
+	if err := putScalars(out, sig.C, sig.S); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, see MarshalBinary.
+// Use ParseRingSignatureBinary to also check the ring size.
+func (sig *RingSignature) UnmarshalBinary(data []byte) error {
+	if len(data) < 2*scalarSize || len(data)%scalarSize != 0 {
+		return errInvalidBinary
+	}
+	sig.C, sig.S = getScalars(data)
+	return nil
+}
+
+// ParseRingSignatureBinary parses the compact binary format of a signature
+// over a ring of ringSize members.
+func ParseRingSignatureBinary(data []byte, ringSize int) (*RingSignature, error) {
+	if ringSize < 1 || len(data) != scalarSize*(ringSize+1) {
+		return nil, errInvalidBinary
+	}
+	sig := new(RingSignature)
+	if err := sig.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// ringIDTag tags the optional ring ID, [0] IMPLICIT OCTET STRING.
+var ringIDTag = asn1.Tag(0).ContextSpecific()
+
+func addRingID(b *cryptobyte.Builder, id []byte) {
+	if len(id) != 0 {
+		b.AddASN1(ringIDTag, func(b *cryptobyte.Builder) {
+			b.AddBytes(id)
+		})
+	}
+}
+
+// readRingID reads the optional ring ID, which must not be empty if present.
+func readRingID(s *cryptobyte.String, id *[]byte) bool {
+	var out cryptobyte.String
+	var present bool
+	if !s.ReadOptionalASN1(&out, &present, ringIDTag) {
+		return false
+	}
+	if !present {
+		*id = nil
+		return true
+	}
+	*id = append([]byte{}, out...)
+	return len(out) != 0
+}
+
+func addASN1Int(b *cryptobyte.Builder, n *big.Int) {
+	if n == nil || n.Sign() < 0 {
+		b.SetError(errors.New("sm2rsign: invalid integer"))
+		return
+	}
+	b.AddASN1BigInt(n)
+}
+
+// readASN1Int reads a DER INTEGER and rejects negative values.
+func readASN1Int(s *cryptobyte.String, n *big.Int) bool {
+	return s.ReadASN1Integer(n) && n.Sign() >= 0
+}
+
+// LinkableRingSignature is a linkable ring signature produced by one of the
+// linkable signers. Qx, Qy is the key image used by Linkable.
+//
+// Its ASN.1 DER encoding is:
+//
+//	LinkableRingSignature ::= SEQUENCE {
+//	    scheme    ENUMERATED { base(1), variant1(2), variant2(3), scoped(4) },
+//	    keyImage  OCTET STRING,         -- uncompressed point 04 || Qx || Qy
+//	    c         INTEGER,
+//	    s         SEQUENCE OF INTEGER,  -- s_1, ..., s_n in ring order
+//	    ringID    [0] IMPLICIT OCTET STRING OPTIONAL
+//	}
+type LinkableRingSignature struct {
+	Scheme LinkableScheme
+	Qx, Qy *big.Int
+	C      *big.Int
+	S      []*big.Int
+	// RingID identifies the ring the signature was made over, see RingID.
+	// It is optional: the legacy slice form and the compact binary format
+	// do not carry it.
+	RingID []byte
+}
+
+// NewLinkableRingSignature converts the legacy slice form
+// {Qx, Qy, c, s_1, ..., s_n} into a LinkableRingSignature of the given
+// scheme. See reduceLegacyScalars for how s_i are normalized.
+func NewLinkableRingSignature(scheme LinkableScheme, signature []*big.Int) (*LinkableRingSignature, error) {
+	if len(signature) < 4 {
+		return nil, errors.New("sm2rsign: linkable ring signature is too short")
+	}
+	return &LinkableRingSignature{
+		Scheme: scheme,
+		Qx:     signature[0],
+		Qy:     signature[1],
+		C:      signature[2],
+		S:      reduceLegacyScalars(signature[3:]),
+	}, nil
+}
+
+// RingSize returns the number of ring members the signature was made for.
+func (sig *LinkableRingSignature) RingSize() int {
+	if sig == nil {
+		return 0
+	}
+	return len(sig.S)
+}
+
+// KeyImage returns the uncompressed encoding of the key image, which is
+// suitable as a lookup key for detecting linked signatures. It returns nil
+// if the key image is not a valid SM2 point.
+func (sig *LinkableRingSignature) KeyImage() []byte {
+	if sig == nil {
+		return nil
+	}
+	keyImage, err := marshalPoint(sig.Qx, sig.Qy)
+	if err != nil {
+		return nil
+	}
+	return keyImage
+}
+
+// Slice returns the legacy slice form {Qx, Qy, c, s_1, ..., s_n}.
+func (sig *LinkableRingSignature) Slice() []*big.Int {
+	results := make([]*big.Int, 0, len(sig.S)+3)
+	results = append(results, sig.Qx, sig.Qy, sig.C)
+	return append(results, sig.S...)
+}
+
+// MarshalASN1 returns the ASN.1 DER encoding of the signature.
+func (sig *LinkableRingSignature) MarshalASN1() ([]byte, error) {
+	if !sig.Scheme.valid() {
+		return nil, errors.New("sm2rsign: unknown linkable ring signature scheme")
+	}
+	keyImage, err := marshalPoint(sig.Qx, sig.Qy)
+	if err != nil {
+		return nil, err
+	}
+	var b cryptobyte.Builder
+	b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1Enum(int64(sig.Scheme))
+		b.AddASN1OctetString(keyImage)
+		addASN1Int(b, sig.C)
+		b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			for _, s := range sig.S {
+				addASN1Int(b, s)
+			}
+		})
+		addRingID(b, sig.RingID)
+	})
+	return b.Bytes()
+}
+
+// ParseLinkableRingSignature parses an ASN.1 DER encoded
+// LinkableRingSignature. Unknown schemes, key images which are not valid
+// SM2 points, non-minimal encodings and trailing data are rejected.
+func ParseLinkableRingSignature(der []byte) (*LinkableRingSignature, error) {
+	var inner, values cryptobyte.String
+	var scheme int
+	var keyImage []byte
+	input := cryptobyte.String(der)
+	sig := &LinkableRingSignature{C: new(big.Int)}
+	if !input.ReadASN1(&inner, asn1.SEQUENCE) ||
+		!input.Empty() ||
+		!inner.ReadASN1Enum(&scheme) ||
+		!inner.ReadASN1Bytes(&keyImage, asn1.OCTET_STRING) ||
+		!readASN1Int(&inner, sig.C) ||
+		!inner.ReadASN1(&values, asn1.SEQUENCE) ||
+		!readRingID(&inner, &sig.RingID) ||
+		!inner.Empty() {
+		return nil, errInvalidASN1
+	}
+	sig.Scheme = LinkableScheme(scheme)
+	if !sig.Scheme.valid() {
+		return nil, errInvalidASN1
+	}
+	var err error
+	if sig.Qx, sig.Qy, err = unmarshalPoint(keyImage); err != nil {
+		return nil, err
+	}
+	for !values.Empty() {
+		s := new(big.Int)
+		if !readASN1Int(&values, s) {
+			return nil, errInvalidASN1
+		}
+		sig.S = append(sig.S, s)
+	}
+	if len(sig.S) == 0 {
+		return nil, errInvalidASN1
+	}
+	return sig, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler with the compact
+// fixed-width format Q || c || s_1 || ... || s_n, where Q is the 33-byte
+// compressed key image and every other value is a 32-byte big-endian scalar.
+// The scheme is not encoded and has to be agreed on out of band.
+func (sig *LinkableRingSignature) MarshalBinary() ([]byte, error) {
+	if !validPoint(sig.Qx, sig.Qy) {
+		return nil, errors.New("sm2rsign: invalid key image")
+	}
+	out := make([]byte, compressedPointSize+scalarSize*(len(sig.S)+1))
+	copy(out, elliptic.MarshalCompressed(sm2.P256(), sig.Qx, sig.Qy))
+	if err := putScalars(out[compressedPointSize:], sig.C, sig.S); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, see MarshalBinary.
+// The Scheme field is left unchanged. Use ParseLinkableRingSignatureBinary
+// to also check the ring size.
+func (sig *LinkableRingSignature) UnmarshalBinary(data []byte) error {
+	if len(data) < compressedPointSize+2*scalarSize || (len(data)-compressedPointSize)%scalarSize != 0 {
+		return errInvalidBinary
+	}
+	x, y := elliptic.UnmarshalCompressed(sm2.P256(), data[:compressedPointSize])
+	if x == nil {
+		return errors.New("sm2rsign: invalid key image")
+	}
+	sig.Qx, sig.Qy = x, y
+	sig.C, sig.S = getScalars(data[compressedPointSize:])
+	return nil
+}
+
+// ParseLinkableRingSignatureBinary parses the compact binary format of a
+// signature of the given scheme over a ring of ringSize members.
+func ParseLinkableRingSignatureBinary(scheme LinkableScheme, data []byte, ringSize int) (*LinkableRingSignature, error) {
+	if ringSize < 1 || len(data) != compressedPointSize+scalarSize*(ringSize+1) {
+		return nil, errInvalidBinary
+	}
+	sig := &LinkableRingSignature{Scheme: scheme}
+	if err := sig.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// putScalars writes c, s_1, ..., s_n as fixed-width big-endian values.
+func putScalars(out []byte, c *big.Int, s []*big.Int) error {
+	for i, k := range append([]*big.Int{c}, s...) {
+		if k == nil || k.Sign() < 0 || k.BitLen() > 8*scalarSize {
+			return errors.New("sm2rsign: invalid integer")
+		}
+		k.FillBytes(out[i*scalarSize : (i+1)*scalarSize])
+	}
+	return nil
+}
+
+// getScalars reads c, s_1, ..., s_n from fixed-width big-endian values.
+func getScalars(data []byte) (c *big.Int, s []*big.Int) {
+	c = new(big.Int).SetBytes(data[:scalarSize])
+	for data = data[scalarSize:]; len(data) > 0; data = data[scalarSize:] {
+		s = append(s, new(big.Int).SetBytes(data[:scalarSize]))
+	}
+	return
+}
+
+// marshalPoint returns the uncompressed SEC 1 encoding of an SM2 point.
+func marshalPoint(x, y *big.Int) ([]byte, error) {
+	if !validPoint(x, y) {
+		return nil, errors.New("sm2rsign: invalid key image")
+	}
+	return appendUncompressed(make([]byte, 0, 65), x, y), nil
+}
+
+// appendUncompressed appends the uncompressed SEC 1 encoding of (x, y),
+// which must be reduced coordinates, to b.
+func appendUncompressed(b []byte, x, y *big.Int) []byte {
+	var buf [65]byte
+	buf[0] = 4
+	x.FillBytes(buf[1:33])
+	y.FillBytes(buf[33:])
+	return append(b, buf[:]...)
+}
+
+// unmarshalPoint parses an uncompressed SEC 1 encoded SM2 point and checks
+// that it is on the curve.
+func unmarshalPoint(data []byte) (x, y *big.Int, err error) {
+	if len(data) != 65 || data[0] != 4 {
+		return nil, nil, errors.New("sm2rsign: invalid key image encoding")
+	}
+	x = new(big.Int).SetBytes(data[1:33])
+	y = new(big.Int).SetBytes(data[33:])
+	if !validPoint(x, y) {
+		return nil, nil, errors.New("sm2rsign: invalid key image")
+	}
+	return x, y, nil
+}
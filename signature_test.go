@@ -0,0 +1,321 @@
+package sm2rsign
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"math/big"
+	"testing"
+
+	"github.com/emmansun/gmsm/sm2"
+)
+
+func TestRingSignatureASN1(t *testing.T) {
+	signer, _ := sm2.GenerateKey(rand.Reader)
+	participant, _ := sm2.GenerateKey(rand.Reader)
+	pubs := []*ecdsa.PublicKey{&participant.PublicKey, &signer.PublicKey}
+	msg := []byte("hello world")
+
+	sig, err := Sign(rand.Reader, SimpleParticipantRandInt, signer, pubs, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := sig.MarshalASN1()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseRingSignature(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Verify(pubs, msg, parsed) {
+		t.Errorf("failed to verify the parsed signature")
+	}
+	der2, err := parsed.MarshalASN1()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(der, der2) {
+		t.Errorf("round trip changed the encoding")
+	}
+
+	if _, err := ParseRingSignature(append(der, 0)); err == nil {
+		t.Errorf("expected trailing data to be rejected")
+	}
+	if _, err := ParseRingSignature(der[:len(der)-1]); err == nil {
+		t.Errorf("expected truncated data to be rejected")
+	}
+}
+
+func TestParseRingSignatureStrictDER(t *testing.T) {
+	tests := []struct {
+		name string
+		der  []byte
+	}{
+		{"empty", nil},
+		{"non-minimal integer", []byte{0x30, 0x09, 0x02, 0x02, 0x00, 0x01, 0x30, 0x03, 0x02, 0x01, 0x01}},
+		{"negative integer", []byte{0x30, 0x08, 0x02, 0x01, 0xff, 0x30, 0x03, 0x02, 0x01, 0x01}},
+		{"indefinite length", []byte{0x30, 0x80, 0x02, 0x01, 0x01, 0x30, 0x03, 0x02, 0x01, 0x01, 0x00, 0x00}},
+		{"empty s", []byte{0x30, 0x05, 0x02, 0x01, 0x01, 0x30, 0x00}},
+		{"trailing inner data", []byte{0x30, 0x0b, 0x02, 0x01, 0x01, 0x30, 0x03, 0x02, 0x01, 0x01, 0x05, 0x00}},
+	}
+	for _, tt := range tests {
+		if _, err := ParseRingSignature(tt.der); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+
+	sig, err := ParseRingSignature([]byte{0x30, 0x08, 0x02, 0x01, 0x01, 0x30, 0x03, 0x02, 0x01, 0x02})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig.C.Cmp(big.NewInt(1)) != 0 || len(sig.S) != 1 || sig.S[0].Cmp(big.NewInt(2)) != 0 {
+		t.Errorf("unexpected parse result")
+	}
+}
+
+func TestLinkableRingSignatureASN1(t *testing.T) {
+	signer, _ := sm2.GenerateKey(rand.Reader)
+	participant, _ := sm2.GenerateKey(rand.Reader)
+	pubs := []*ecdsa.PublicKey{&signer.PublicKey, &participant.PublicKey}
+	msg := []byte("hello world")
+
+	signers := []struct {
+		signer   RingSigner
+		verifier schemeVerifier
+		scheme   LinkableScheme
+	}{
+		{NewBaseLinkableSigner(signer, pubs), NewBaseLinkableVerfier(pubs), LinkableSchemeBase},
+		{NewLinkableSignerVariant1(signer, pubs), NewLinkableVerfierVariant1(pubs), LinkableSchemeVariant1},
+		{NewLinkableSignerVariant2(signer, pubs), NewLinkableVerfierVariant2(pubs), LinkableSchemeVariant2},
+	}
+	var encoded [][]byte
+	for _, s := range signers {
+		sig, err := s.signer.Sign(rand.Reader, SimpleParticipantRandInt, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sig.Scheme != s.scheme {
+			t.Errorf("%v: signer produced scheme %v", s.scheme, sig.Scheme)
+		}
+		der, err := sig.MarshalASN1()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := ParseLinkableRingSignature(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed.Scheme != s.scheme {
+			t.Errorf("%v: got scheme %v", s.scheme, parsed.Scheme)
+		}
+		if !s.verifier.Verify(msg, parsed) {
+			t.Errorf("%v: failed to verify the parsed signature", s.scheme)
+		}
+		if _, err := ParseLinkableRingSignature(append(der, 0)); err == nil {
+			t.Errorf("%v: expected trailing data to be rejected", s.scheme)
+		}
+		encoded = append(encoded, der)
+	}
+
+	base := NewBaseLinkableVerfier(pubs)
+	v1 := NewLinkableVerfierVariant1(pubs)
+	v2 := NewLinkableVerfierVariant2(pubs)
+	if !base.VerifyASN1(msg, encoded[0]) || !v1.VerifyASN1(msg, encoded[1]) || !v2.VerifyASN1(msg, encoded[2]) {
+		t.Errorf("failed to verify ASN.1 encoded signatures")
+	}
+	if base.VerifyASN1(msg, encoded[1]) || base.VerifyASN1(msg, encoded[2]) {
+		t.Errorf("base verifier accepted a signature of another scheme")
+	}
+	if v1.VerifyASN1(msg, encoded[0]) || v1.VerifyASN1(msg, encoded[2]) {
+		t.Errorf("variant 1 verifier accepted a signature of another scheme")
+	}
+	if v2.VerifyASN1(msg, encoded[0]) || v2.VerifyASN1(msg, encoded[1]) {
+		t.Errorf("variant 2 verifier accepted a signature of another scheme")
+	}
+}
+
+func TestParseLinkableRingSignatureInvalidKeyImage(t *testing.T) {
+	sig := &LinkableRingSignature{
+		Scheme: LinkableSchemeBase,
+		Qx:     big.NewInt(1),
+		Qy:     big.NewInt(2),
+		C:      big.NewInt(3),
+		S:      []*big.Int{big.NewInt(4), big.NewInt(5)},
+	}
+	if _, err := sig.MarshalASN1(); err == nil {
+		t.Errorf("expected off-curve key image to be rejected")
+	}
+	sig.Qx, sig.Qy = sm2.P256().Params().Gx, sm2.P256().Params().Gy
+	sig.Scheme = 0
+	if _, err := sig.MarshalASN1(); err == nil {
+		t.Errorf("expected unknown scheme to be rejected")
+	}
+	sig.Scheme = LinkableSchemeVariant2
+	der, err := sig.MarshalASN1()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// corrupt the last byte of Qy
+	der[2+3+2+64] ^= 1
+	if _, err := ParseLinkableRingSignature(der); err == nil {
+		t.Errorf("expected off-curve key image to be rejected")
+	}
+}
+
+func TestRingSignatureBinary(t *testing.T) {
+	signer, _ := sm2.GenerateKey(rand.Reader)
+	participant1, _ := sm2.GenerateKey(rand.Reader)
+	participant2, _ := sm2.GenerateKey(rand.Reader)
+	pubs := []*ecdsa.PublicKey{&participant1.PublicKey, &signer.PublicKey, &participant2.PublicKey}
+	msg := []byte("hello world")
+
+	sig, err := Sign(rand.Reader, SM2ParticipantRandInt, signer, pubs, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := sig.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 32*4 {
+		t.Fatalf("unexpected length %d", len(data))
+	}
+	parsed, err := ParseRingSignatureBinary(data, len(pubs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Verify(pubs, msg, parsed) {
+		t.Errorf("failed to verify the parsed signature")
+	}
+	if _, err := ParseRingSignatureBinary(data, len(pubs)-1); err == nil {
+		t.Errorf("expected ring size mismatch to be rejected")
+	}
+	if _, err := ParseRingSignatureBinary(data[:len(data)-1], len(pubs)); err == nil {
+		t.Errorf("expected truncated data to be rejected")
+	}
+	var unmarshaled RingSignature
+	if err := unmarshaled.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !Verify(pubs, msg, &unmarshaled) {
+		t.Errorf("failed to verify the unmarshaled signature")
+	}
+	if err := unmarshaled.UnmarshalBinary(data[1:]); err == nil {
+		t.Errorf("expected misaligned data to be rejected")
+	}
+}
+
+func TestLinkableRingSignatureBinary(t *testing.T) {
+	signer, _ := sm2.GenerateKey(rand.Reader)
+	participant, _ := sm2.GenerateKey(rand.Reader)
+	pubs := []*ecdsa.PublicKey{&participant.PublicKey, &signer.PublicKey}
+	msg := []byte("hello world")
+
+	baseSigner := NewBaseLinkableSigner(signer, pubs)
+	sig, err := baseSigner.Sign(rand.Reader, SimpleParticipantRandInt, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := sig.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 33+32*3 {
+		t.Fatalf("unexpected length %d", len(data))
+	}
+	parsed, err := ParseLinkableRingSignatureBinary(LinkableSchemeBase, data, len(pubs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Qx.Cmp(sig.Qx) != 0 || parsed.Qy.Cmp(sig.Qy) != 0 {
+		t.Errorf("key image changed after round trip")
+	}
+	if !baseSigner.Verify(msg, parsed) {
+		t.Errorf("failed to verify the parsed signature")
+	}
+	if _, err := ParseLinkableRingSignatureBinary(LinkableSchemeBase, data, len(pubs)+1); err == nil {
+		t.Errorf("expected ring size mismatch to be rejected")
+	}
+	data[0] = 4
+	if _, err := ParseLinkableRingSignatureBinary(LinkableSchemeBase, data, len(pubs)); err == nil {
+		t.Errorf("expected invalid key image to be rejected")
+	}
+}
+
+func TestLegacySliceAdapters(t *testing.T) {
+	signer, _ := sm2.GenerateKey(rand.Reader)
+	participant, _ := sm2.GenerateKey(rand.Reader)
+	pubs := []*ecdsa.PublicKey{&participant.PublicKey, &signer.PublicKey}
+	msg := []byte("hello world")
+
+	sig, err := Sign(rand.Reader, SimpleParticipantRandInt, signer, pubs, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := sig.Slice()
+	if len(legacy) != len(pubs)+1 || legacy[0] != sig.C {
+		t.Fatalf("unexpected legacy slice")
+	}
+	restored, err := NewRingSignature(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.RingSize() != len(pubs) || !Verify(pubs, msg, restored) {
+		t.Errorf("failed to verify the restored signature")
+	}
+
+	signer1 := NewLinkableSignerVariant1(signer, pubs)
+	lsig, err := signer1.Sign(rand.Reader, SimpleParticipantRandInt, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	llegacy := lsig.Slice()
+	if len(llegacy) != len(pubs)+3 || llegacy[0] != lsig.Qx || llegacy[2] != lsig.C {
+		t.Fatalf("unexpected legacy slice")
+	}
+	lrestored, err := NewLinkableRingSignature(LinkableSchemeVariant1, llegacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lrestored.RingSize() != len(pubs) || !signer1.Verify(msg, lrestored) {
+		t.Errorf("failed to verify the restored signature")
+	}
+	if !bytes.Equal(lrestored.KeyImage(), lsig.KeyImage()) || lsig.KeyImage() == nil {
+		t.Errorf("unexpected key image")
+	}
+
+	// a signature restored with the wrong scheme must not verify
+	lrestored.Scheme = LinkableSchemeBase
+	if signer1.Verify(msg, lrestored) {
+		t.Errorf("verified a signature marked with another scheme")
+	}
+	if _, err := NewLinkableRingSignature(LinkableSchemeBase, llegacy[:3]); err == nil {
+		t.Errorf("expected short slice to be rejected")
+	}
+}
+
+func TestLegacyUnreducedScalars(t *testing.T) {
+	signer, _ := sm2.GenerateKey(rand.Reader)
+	participant, _ := sm2.GenerateKey(rand.Reader)
+	pubs := []*ecdsa.PublicKey{&participant.PublicKey, &signer.PublicKey}
+	msg := []byte("hello world")
+
+	sig, err := Sign(rand.Reader, SM2ParticipantRandInt, signer, pubs, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// earlier versions of SM2ParticipantRandInt could return r + k >= N
+	legacy := sig.Slice()
+	legacy[1] = new(big.Int).Add(legacy[1], sm2.P256().Params().N)
+	if Verify(pubs, msg, &RingSignature{C: legacy[0], S: legacy[1:]}) {
+		t.Errorf("verified an unreduced scalar")
+	}
+	restored, err := NewRingSignature(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Verify(pubs, msg, restored) {
+		t.Errorf("failed to verify the restored legacy signature")
+	}
+}